@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"path"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/golang/glog"
@@ -16,11 +17,12 @@ import (
 	clientset "k8s.io/client-go/kubernetes"
 	clientrest "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/helm/pkg/repo"
 
 	"github.com/huangjiuyuan/helm-broker/pkg/broker"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	"github.com/huangjiuyuan/helm-broker/pkg/rest"
 	"github.com/pmorie/osb-broker-lib/pkg/metrics"
-	"github.com/pmorie/osb-broker-lib/pkg/rest"
-	"github.com/pmorie/osb-broker-lib/pkg/server"
 )
 
 var options struct {
@@ -68,6 +70,9 @@ func runWithContext(ctx context.Context) error {
 		fmt.Printf("%s/%s\n", path.Base(os.Args[0]), "0.1.0")
 		return nil
 	}
+	if flag.Arg(0) == "bundle" {
+		return runBundle(ctx, flag.Args()[1:])
+	}
 	if (options.TLSCert != "" || options.TLSKey != "") &&
 		(options.TLSCert == "" || options.TLSKey == "") {
 		fmt.Println("To use TLS with specified cert or key data, both --tlsCert and --tlsKey must be used")
@@ -76,7 +81,7 @@ func runWithContext(ctx context.Context) error {
 
 	addr := ":" + strconv.Itoa(options.Port)
 
-	businessLogic, err := broker.NewHelmBroker(options.Options)
+	businessLogic, err := broker.NewHelmBroker(ctx, options.Options)
 	if err != nil {
 		return err
 	}
@@ -85,13 +90,14 @@ func runWithContext(ctx context.Context) error {
 	reg := prom.NewRegistry()
 	osbMetrics := metrics.New()
 	reg.MustRegister(osbMetrics)
+	reg.MustRegister(businessLogic.Metrics())
 
 	api, err := rest.NewAPISurface(businessLogic, osbMetrics)
 	if err != nil {
 		return err
 	}
 
-	s := server.New(api, reg)
+	s := rest.NewServer(api, reg)
 	if options.AuthenticateK8SToken {
 		// get k8s client
 		k8sClient, err := getKubernetesClient(options.KubeConfig)
@@ -131,6 +137,38 @@ func runWithContext(ctx context.Context) error {
 	return err
 }
 
+// runBundle creates an offline chart bundle from the repositories given as
+// name=url arguments.
+func runBundle(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	output := fs.String("o", "charts.tgz", "The path of the bundle, a gzipped tarball if it ends with .tgz or .tar.gz and a directory otherwise")
+	allVersions := fs.Bool("all-versions", false, "Bundle all versions of the charts instead of the latest ones")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bundle [flags] name=url...\n", path.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var repos []*repo.Entry
+	for _, arg := range fs.Args() {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid repository %q, expected name=url", arg)
+		}
+		repos = append(repos, &repo.Entry{Name: parts[0], URL: parts[1]})
+	}
+	if len(repos) == 0 {
+		fs.Usage()
+		return fmt.Errorf("no repositories to bundle")
+	}
+
+	if err := helm.CreateBundle(ctx, repos, *allVersions, *output); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote bundle of %d repositories to %s\n", len(repos), *output)
+	return nil
+}
+
 func getKubernetesClient(kubeConfigPath string) (clientset.Interface, error) {
 	var clientConfig *clientrest.Config
 	var err error
@@ -154,7 +192,7 @@ func getKubernetesClient(kubeConfigPath string) (clientset.Interface, error) {
 }
 
 func cancelOnInterrupt(ctx context.Context, f context.CancelFunc) {
-	term := make(chan os.Signal)
+	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
 	for {
@@ -0,0 +1,337 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/ghodss/yaml"
+	"github.com/huangjiuyuan/helm-broker/pkg/kube"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// bindableAnnotation is the chart annotation marking the services of a chart
+// as bindable when set to "true".
+const bindableAnnotation = "helm-broker.io/bindable"
+
+// schemePattern matches port names which can be used as URI schemes.
+var schemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+// bindingTemplateAnnotation is the chart annotation holding a binding
+// template, which is rendered into the binding credentials, e.g.
+//
+//   uri: mysql://root:{{ index .Secrets "db-mysql" "mysql-root-password" }}@{{ (index .Services "db-mysql").Host }}
+const bindingTemplateAnnotation = "helm-broker.io/binding-template"
+
+// bindingData is the data the binding template is rendered with.
+type bindingData struct {
+	// Release holds the name and namespace of the release.
+	Release struct {
+		Name      string
+		Namespace string
+	}
+	// Values holds the values supplied to the release.
+	Values map[string]interface{}
+	// Secrets holds the data of the Secrets of the release keyed by Secret
+	// name and key.
+	Secrets map[string]map[string]string
+	// Services holds the addresses of the Services of the release keyed by
+	// Service name.
+	Services map[string]kube.ServiceAddress
+
+	// services holds the addresses of the Services in manifest order.
+	services []kube.ServiceAddress
+	// chart is the name of the chart of the release.
+	chart string
+	// kubeClient is the clientset for kubernetes of the cluster of the
+	// release.
+	kubeClient kubeclientset.Interface
+}
+
+// bindingFuncs are the functions available to binding templates in addition to
+// the predefined functions of text/template. These are the Sprig functions
+// which are available to chart templates, without the functions reading the
+// environment of the broker.
+var bindingFuncs = func() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	return funcs
+}()
+
+// isBindable returns true if the chart is configured or annotated as bindable,
+// or if it has a binding template.
+func isBindable(annotations map[string]string, chartConfig ChartConfig) bool {
+	if chartConfig.Bindable != nil {
+		return *chartConfig.Bindable
+	}
+	if configOrAnnotation(chartConfig.BindingTemplate, annotations, bindingTemplateAnnotation) != "" ||
+		configOrAnnotation(chartConfig.BindJob, annotations, bindJobAnnotation) != "" {
+		return true
+	}
+	bindable, _ := strconv.ParseBool(annotations[bindableAnnotation])
+	return bindable
+}
+
+// getCredentials assembles the credentials of a binding. If the chart has a
+// bind job, the job is run to create a dedicated user for the binding.
+// Otherwise the binding template of the chart config or the chart annotation
+// is rendered if there is one, or the credentials are derived from the
+// Services and Secrets of the release.
+func (b *HelmBroker) getCredentials(ctx context.Context, ref *releaseRef, bindingID string, parameters map[string]interface{}, chartConfig ChartConfig) (map[string]interface{}, error) {
+	name := ref.name
+	data, annotations, err := b.getBindingData(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if job := configOrAnnotation(chartConfig.BindJob, annotations, bindJobAnnotation); job != "" {
+		return b.runBindJob(ctx, job, data, bindingID, parameters, chartConfig.bindTimeout())
+	}
+
+	text := configOrAnnotation(chartConfig.BindingTemplate, annotations, bindingTemplateAnnotation)
+	if text == "" {
+		return data.credentials(), nil
+	}
+
+	tmpl, err := template.New("binding").Funcs(bindingFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse binding template of release %s: %v", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render binding template of release %s: %v", name, err)
+	}
+
+	credentials := map[string]interface{}{}
+	if err := yaml.Unmarshal(buf.Bytes(), &credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse rendered binding template of release %s: %v", name, err)
+	}
+	return credentials, nil
+}
+
+// revokeCredentials revokes the credentials of a binding created by the bind
+// job of the chart. Nothing is done for charts without a bind job.
+func (b *HelmBroker) revokeCredentials(ctx context.Context, ref *releaseRef, bindingID string, chartConfig ChartConfig) error {
+	data, annotations, err := b.getBindingData(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	if configOrAnnotation(chartConfig.BindJob, annotations, bindJobAnnotation) == "" {
+		return nil
+	}
+	job := configOrAnnotation(chartConfig.UnbindJob, annotations, unbindJobAnnotation)
+	return b.runUnbindJob(ctx, job, data, bindingID, chartConfig.bindTimeout())
+}
+
+// hasBindJob returns true if the chart of a release has a bind job. Bindings
+// of such charts are always created and deleted asynchronously, since the jobs
+// may run for the bind timeout.
+func (b *HelmBroker) hasBindJob(ctx context.Context, ref *releaseRef, chartConfig ChartConfig) (bool, error) {
+	if chartConfig.BindJob != "" {
+		return true, nil
+	}
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return false, err
+	}
+	content, err := helmClient.ReleaseContent(ctx, ref.name)
+	if err != nil {
+		return false, err
+	}
+	annotations := content.GetRelease().GetChart().GetMetadata().GetAnnotations()
+	return annotations[bindJobAnnotation] != "", nil
+}
+
+// asyncRequiredError is returned for bind and unbind requests of charts with a
+// bind job which do not accept asynchronous operations.
+func asyncRequiredError() error {
+	message := osb.AsyncErrorMessage
+	description := osb.AsyncErrorDescription
+	return osb.HTTPStatusCodeError{
+		StatusCode:   http.StatusUnprocessableEntity,
+		ErrorMessage: &message,
+		Description:  &description,
+	}
+}
+
+// getBindingData returns the binding data and the chart annotations of a
+// release.
+func (b *HelmBroker) getBindingData(ctx context.Context, ref *releaseRef) (*bindingData, map[string]string, error) {
+	name := ref.name
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := helmClient.ReleaseContent(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	rel := content.GetRelease()
+
+	resources, err := kube.ParseManifest(rel.GetManifest())
+	if err != nil {
+		return nil, nil, err
+	}
+	services, err := kube.GetServiceAddresses(ref.cluster.kubeClient, rel.GetNamespace(), resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	secrets, err := kube.GetSecretData(ref.cluster.kubeClient, rel.GetNamespace(), resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := chartutil.ReadValues([]byte(rel.GetConfig().GetRaw()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read values of release %s: %v", name, err)
+	}
+
+	data := &bindingData{
+		Values:     values,
+		Secrets:    secrets,
+		Services:   make(map[string]kube.ServiceAddress, len(services)),
+		services:   services,
+		chart:      rel.GetChart().GetMetadata().GetName(),
+		kubeClient: ref.cluster.kubeClient,
+	}
+	data.Release.Name = rel.GetName()
+	data.Release.Namespace = rel.GetNamespace()
+	for _, service := range services {
+		data.Services[service.Name] = service
+	}
+
+	return data, rel.GetChart().GetMetadata().GetAnnotations(), nil
+}
+
+// credentials derives the binding credentials from the binding data. The
+// credentials hold the host and port of the first Service with a cluster IP,
+// the username and password found in the Secrets or the values of the release,
+// and a URI built from them.
+func (d *bindingData) credentials() map[string]interface{} {
+	credentials := map[string]interface{}{}
+	var service *kube.ServiceAddress
+	for i := range d.services {
+		if !d.services[i].Headless {
+			service = &d.services[i]
+			break
+		}
+	}
+	if service != nil {
+		credentials["host"] = service.Host
+		credentials["port"] = service.Port
+	}
+
+	passwordKey, password := findPassword(d.Secrets)
+	if password != "" {
+		credentials["password"] = password
+	}
+	username := findUsername(d.Secrets, d.Values)
+	if username == "" && strings.Contains(passwordKey, "root") {
+		username = "root"
+	}
+	if username != "" {
+		credentials["username"] = username
+	}
+
+	if service != nil {
+		scheme := d.chart
+		if schemePattern.MatchString(service.PortName) {
+			scheme = service.PortName
+		}
+		uri := url.URL{
+			Scheme: scheme,
+			Host:   fmt.Sprintf("%s:%d", service.Host, service.Port),
+		}
+		if password != "" {
+			uri.User = url.UserPassword(username, password)
+		} else if username != "" {
+			uri.User = url.User(username)
+		}
+		credentials["uri"] = uri.String()
+	}
+
+	return credentials
+}
+
+// findPassword returns the key and value of the password in the Secrets. A key
+// named "password" is preferred over keys ending with "password", and keys of
+// non-root users are preferred over keys of root users.
+func findPassword(secrets map[string]map[string]string) (string, string) {
+	var keys []string
+	for secret, data := range secrets {
+		for key := range data {
+			keys = append(keys, secret+"/"+key)
+		}
+	}
+	sort.Strings(keys)
+
+	rank := func(key string) int {
+		key = strings.ToLower(key[strings.Index(key, "/")+1:])
+		switch {
+		case key == "password":
+			return 0
+		case strings.HasSuffix(key, "password") && !strings.Contains(key, "root"):
+			return 1
+		case strings.HasSuffix(key, "password"):
+			return 2
+		}
+		return -1
+	}
+
+	best, bestRank := "", -1
+	for _, key := range keys {
+		if r := rank(key); r >= 0 && (bestRank < 0 || r < bestRank) {
+			best, bestRank = key, r
+		}
+	}
+	if best == "" {
+		return "", ""
+	}
+
+	i := strings.Index(best, "/")
+	return best[i+1:], secrets[best[:i]][best[i+1:]]
+}
+
+// findUsername returns the username in the Secrets, or in the top-level values
+// of the release ending with "User" or "Username", such as "mysqlUser".
+func findUsername(secrets map[string]map[string]string, values map[string]interface{}) string {
+	var keys []string
+	for secret, data := range secrets {
+		for key := range data {
+			keys = append(keys, secret+"/"+key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		i := strings.Index(key, "/")
+		k := strings.ToLower(key[i+1:])
+		if k == "username" || k == "user" || strings.HasSuffix(k, "-user") || strings.HasSuffix(k, "-username") {
+			return secrets[key[:i]][key[i+1:]]
+		}
+	}
+
+	keys = keys[:0]
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if user, ok := values[key].(string); ok && user != "" &&
+			(strings.HasSuffix(key, "User") || strings.HasSuffix(key, "Username")) {
+			return user
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,132 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	"github.com/huangjiuyuan/helm-broker/pkg/kube"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// bindJobAnnotation is the chart annotation holding a template of a Job which
+// creates a dedicated user for a binding. The Job must write the credentials
+// of the user into the Secret named by .Binding.SecretName.
+const bindJobAnnotation = "helm-broker.io/bind-job"
+
+// unbindJobAnnotation is the chart annotation holding a template of a Job
+// which revokes the user created by the bind job.
+const unbindJobAnnotation = "helm-broker.io/unbind-job"
+
+// bindingLabel is the label holding the binding ID of the resources created
+// for a binding.
+const bindingLabel = "helm-broker.io/binding"
+
+// bindJobData is the data bind and unbind job templates are rendered with.
+type bindJobData struct {
+	*bindingData
+	// Binding holds the binding the job runs for.
+	Binding struct {
+		// ID of the binding.
+		ID string
+		// SecretName is the name of the Secret holding the credentials of
+		// the binding.
+		SecretName string
+		// Parameters holds the parameters of the bind request.
+		Parameters map[string]interface{}
+	}
+}
+
+// getBindingSecretName returns the name of the Secret holding the credentials
+// created by the bind job of a binding.
+func getBindingSecretName(release string, bindingID string) string {
+	return fmt.Sprintf("%s-binding-%s", release, bindingID)
+}
+
+// runBindJob runs the bind job of a binding and returns the credentials the job
+// wrote into the binding Secret.
+func (b *HelmBroker) runBindJob(ctx context.Context, text string, data *bindingData, bindingID string, parameters map[string]interface{}, timeout time.Duration) (map[string]interface{}, error) {
+	jobData := &bindJobData{bindingData: data}
+	jobData.Binding.ID = bindingID
+	jobData.Binding.SecretName = getBindingSecretName(data.Release.Name, bindingID)
+	jobData.Binding.Parameters = parameters
+
+	if err := b.runBindingJob(ctx, "bind", text, jobData, timeout); err != nil {
+		return nil, err
+	}
+
+	secret, err := kube.GetSecret(data.kubeClient, data.Release.Namespace, jobData.Binding.SecretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials of binding %s: %v", bindingID, err)
+	}
+	credentials := make(map[string]interface{}, len(secret))
+	for k, v := range secret {
+		credentials[k] = v
+	}
+	return credentials, nil
+}
+
+// runUnbindJob runs the unbind job of a binding, if there is one, and deletes
+// the binding Secret.
+func (b *HelmBroker) runUnbindJob(ctx context.Context, text string, data *bindingData, bindingID string, timeout time.Duration) error {
+	jobData := &bindJobData{bindingData: data}
+	jobData.Binding.ID = bindingID
+	jobData.Binding.SecretName = getBindingSecretName(data.Release.Name, bindingID)
+
+	if text != "" {
+		if err := b.runBindingJob(ctx, "unbind", text, jobData, timeout); err != nil {
+			return err
+		}
+	}
+
+	return kube.DeleteSecret(data.kubeClient, data.Release.Namespace, jobData.Binding.SecretName)
+}
+
+// runBindingJob renders a job template and runs the job in the namespace of the
+// release.
+func (b *HelmBroker) runBindingJob(ctx context.Context, kind string, text string, data *bindJobData, timeout time.Duration) error {
+	tmpl, err := template.New(kind).Funcs(bindingFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s job template of release %s: %v", kind, data.Release.Name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render %s job template of release %s: %v", kind, data.Release.Name, err)
+	}
+
+	job := &batchv1.Job{}
+	if err := yaml.Unmarshal(buf.Bytes(), job); err != nil {
+		return fmt.Errorf("failed to parse rendered %s job of release %s: %v", kind, data.Release.Name, err)
+	}
+
+	// Job names are limited to 63 characters since they are used as label
+	// values of the pods.
+	suffix := fmt.Sprintf("-%s-%x", kind, sha256.Sum256([]byte(data.Binding.ID)))[:len(kind)+10]
+	prefix := data.Release.Name
+	if len(prefix)+len(suffix) > 63 {
+		prefix = prefix[:63-len(suffix)]
+	}
+	job.Name = prefix + suffix
+	job.Namespace = data.Release.Namespace
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	job.Labels[bindingLabel] = data.Binding.ID
+
+	glog.Infof("running %s job %s for binding %s.", kind, job.Name, data.Binding.ID)
+	return kube.RunJob(ctx, data.kubeClient, job, bindingLabel, timeout)
+}
+
+// bindTimeout returns the timeout of bind and unbind jobs.
+func (c ChartConfig) bindTimeout() time.Duration {
+	if c.BindTimeout != nil {
+		return time.Duration(*c.BindTimeout) * time.Second
+	}
+	return time.Duration(helm.DefaultTimeout) * time.Second
+}
@@ -2,16 +2,32 @@ package broker
 
 import (
 	"flag"
+	"time"
+
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
 )
 
 // Options holds the options specified by the broker's code on the command
 // line. Users should add their own options here and add flags for them in
 // AddFlags.
 type Options struct {
-	CatalogPath string
-	Async       bool
-	TillerHost  string
-	HelmHome    string
+	CatalogPath        string
+	Async              bool
+	TillerHost         string
+	TillerNamespace    string
+	HelmHome           string
+	ConfigPath         string
+	Namespace          string
+	BrokerName         string
+	Backend            string
+	TillerTLS          helm.TLSOptions
+	TillerPerNamespace bool
+	HelmRetryBudget    time.Duration
+	ChartCacheDir      string
+	ChartCacheMaxSize  int64
+	ChartCacheMaxAge   time.Duration
+	ChartBundle        string
+	ChartSelector      string
 }
 
 // AddFlags is a hook called to initialize the CLI flags for broker options.
@@ -20,6 +36,23 @@ type Options struct {
 func AddFlags(o *Options) {
 	flag.StringVar(&o.CatalogPath, "catalogPath", "", "The path to the catalog")
 	flag.BoolVar(&o.Async, "async", false, "Indicates whether the broker is handling the requests asynchronously.")
-	flag.StringVar(&o.TillerHost, "tillerHost", "", "The host and port of Tiller")
+	flag.StringVar(&o.TillerHost, "tillerHost", "", "The host and port of Tiller, which is discovered in the Tiller namespace if it is empty")
+	flag.StringVar(&o.TillerNamespace, "tillerNamespace", "kube-system", "The namespace Tiller is discovered in")
 	flag.StringVar(&o.HelmHome, "helmHome", "", "The local path to the Helm home directory")
+	flag.StringVar(&o.ConfigPath, "configPath", "", "The path to the config file of charts and plans")
+	flag.StringVar(&o.Namespace, "namespace", "", "The namespace the broker stores its bindings and releases in, defaults to the namespace of the broker pod")
+	flag.StringVar(&o.BrokerName, "brokerName", "helm-broker", "The name of the ClusterServiceBroker the broker is registered as")
+	flag.StringVar(&o.TillerTLS.CACertFile, "tillerTLSCACert", "", "The path to the CA certificate verifying Tiller, which enables the verification")
+	flag.StringVar(&o.TillerTLS.CertFile, "tillerTLSCert", "", "The path to the client certificate for Tiller, which enables TLS")
+	flag.StringVar(&o.TillerTLS.KeyFile, "tillerTLSKey", "", "The path to the key of the client certificate for Tiller")
+	flag.StringVar(&o.TillerTLS.ServerName, "tillerTLSServerName", "", "The server name the certificate of Tiller is verified against, defaults to the host of Tiller")
+	flag.BoolVar(&o.TillerTLS.Verify, "tillerTLSVerify", false, "Indicates whether the certificate of Tiller is verified against the system CAs if no CA certificate is given")
+	flag.BoolVar(&o.TillerPerNamespace, "tillerPerNamespace", false, "Indicates whether releases are managed by the Tiller discovered in their namespace instead of the default Tiller")
+	flag.DurationVar(&o.HelmRetryBudget, "helmRetryBudget", helm.DefaultRetryPolicy.Budget, "The maximum time a call to Tiller or a chart download is retried for after transient failures, 0 disables retries")
+	flag.StringVar(&o.ChartCacheDir, "chartCacheDir", "", "The directory charts downloaded from chart repositories are cached in, defaults to the cache of the Helm home")
+	flag.Int64Var(&o.ChartCacheMaxSize, "chartCacheMaxSize", 1<<30, "The maximum size of the chart cache in bytes, beyond which the least recently used charts are evicted, 0 disables the limit")
+	flag.DurationVar(&o.ChartCacheMaxAge, "chartCacheMaxAge", 0, "The time after which unused charts are evicted from the chart cache, 0 disables the limit")
+	flag.StringVar(&o.ChartBundle, "chartBundle", "", "The path to an offline chart bundle, a directory or gzipped tarball, which the catalog and charts are served from instead of chart repositories")
+	flag.StringVar(&o.ChartSelector, "chartSelector", "", "The label selector of the ConfigMaps and Secrets in the broker namespace holding chart archives, which are served as charts of the \"cluster\" repository")
+	flag.StringVar(&o.Backend, "backend", "tiller", "The backend managing releases, either \"tiller\" or \"local\" to install releases without Tiller")
 }
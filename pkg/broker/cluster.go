@@ -0,0 +1,216 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	"github.com/huangjiuyuan/helm-broker/pkg/store"
+	"github.com/kubernetes-incubator/service-catalog/pkg/apis/servicecatalog/v1beta1"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// clusterParameter is the provision parameter choosing the target cluster of
+// an instance. It is not passed on to the chart.
+const clusterParameter = "helm-broker.io/cluster"
+
+// cluster is a cluster releases are installed in.
+type cluster struct {
+	// name of the cluster, which is empty for the cluster the broker runs in.
+	name string
+	// Clientset for kubernetes.
+	kubeClient kubeclientset.Interface
+	// Clients for helm of the Tillers serving namespaces.
+	helmClients *helm.Pool
+}
+
+// newClusters creates the cluster the broker runs in, keyed by an empty name,
+// and the target clusters of the config.
+func newClusters(o Options, config *Config, kubeClient kubeclientset.Interface, configure func(*helm.Client)) (map[string]*cluster, error) {
+	if err := validateRegistryRewrites(o, config.RegistryRewrites); err != nil {
+		return nil, err
+	}
+	helmClients, err := newHelmClients(o, config.Tillers, kubeClient, configure)
+	if err != nil {
+		return nil, err
+	}
+	clusters := map[string]*cluster{
+		"": {kubeClient: kubeClient, helmClients: helmClients},
+	}
+
+	for name, clusterConfig := range config.Clusters {
+		restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: clusterConfig.Kubeconfig},
+			&clientcmd.ConfigOverrides{CurrentContext: clusterConfig.Context},
+		).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build config of cluster %s: %v", name, err)
+		}
+		kubeClient, err := kubeclientset.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes client of cluster %s: %v", name, err)
+		}
+
+		clusterOptions := o
+		clusterOptions.TillerHost = clusterConfig.TillerHost
+		if clusterConfig.Backend != "" {
+			clusterOptions.Backend = clusterConfig.Backend
+		}
+		if err := validateRemoteTillers(clusterOptions, clusterConfig.Tillers); err != nil {
+			return nil, fmt.Errorf("invalid config of cluster %s: %v", name, err)
+		}
+		rewrites := config.RegistryRewrites
+		if clusterConfig.RegistryRewrites != nil {
+			rewrites = clusterConfig.RegistryRewrites
+		}
+		if err := validateRegistryRewrites(clusterOptions, rewrites); err != nil {
+			return nil, fmt.Errorf("invalid config of cluster %s: %v", name, err)
+		}
+		configureCluster := configure
+		if clusterConfig.RegistryRewrites != nil {
+			configureCluster = func(client *helm.Client) {
+				configure(client)
+				client.SetRegistryRewrites(rewrites)
+			}
+		}
+		helmClients, err := newHelmClients(clusterOptions, clusterConfig.Tillers, kubeClient, configureCluster)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create helm clients of cluster %s: %v", name, err)
+		}
+
+		clusters[name] = &cluster{name: name, kubeClient: kubeClient, helmClients: helmClients}
+	}
+	return clusters, nil
+}
+
+// validateRemoteTillers returns an error if a Tiller of a remote cluster has no
+// host. Tillers are discovered by the addresses of their Services and pods,
+// which cannot be reached from outside of the cluster in general.
+func validateRemoteTillers(o Options, tillers map[string]TillerConfig) error {
+	if o.Backend == "local" {
+		return nil
+	}
+	if o.TillerHost == "" {
+		return fmt.Errorf("tillerHost is required with the Tiller backend")
+	}
+	if o.TillerPerNamespace {
+		return fmt.Errorf("tillerPerNamespace is not supported, since Tillers cannot be discovered")
+	}
+	for namespace, tiller := range tillers {
+		if tiller.Host == "" {
+			return fmt.Errorf("the host of the Tiller of namespace %s is required with the Tiller backend", namespace)
+		}
+	}
+	return nil
+}
+
+// validateRegistryRewrites returns an error if the registry rewrites cannot be
+// enforced by the backend. Tiller renders the manifests itself, so releases of
+// Tiller are only rewritten through the global.imageRegistry value, which
+// requires a single mirror matching the default registry. Since charts which
+// do not read the value pull their images from the original registries, a
+// warning is logged for rules Tiller can apply.
+func validateRegistryRewrites(o Options, rewrites helm.RegistryRewrites) error {
+	if o.Backend == "local" || len(rewrites) == 0 {
+		return nil
+	}
+	registry := rewrites.GlobalRegistry()
+	if registry == "" {
+		return fmt.Errorf("registry rewrites %s cannot be enforced by Tiller, which needs a single mirror matching docker.io", rewrites)
+	}
+	glog.Warningf("images of Tiller releases are only rewritten by charts reading global.imageRegistry=%s.", registry)
+	return nil
+}
+
+// releaseRef refers to the release of an instance in its cluster.
+type releaseRef struct {
+	cluster   *cluster
+	namespace string
+	name      string
+}
+
+// helmClient returns the client for helm managing the release.
+func (r *releaseRef) helmClient() (*helm.Client, error) {
+	return r.cluster.helmClients.Client(r.namespace)
+}
+
+// selectCluster returns the target cluster of a provision request, which is
+// chosen by the release config of the plan, the cluster parameter, or the
+// cluster ID in the context of the request, in this order. A cluster parameter
+// conflicting with the cluster of the plan is rejected. The parameters are
+// returned without the cluster parameter.
+func (b *HelmBroker) selectCluster(request *osb.ProvisionRequest, releaseConfig ReleaseConfig) (*cluster, map[string]interface{}, error) {
+	name := releaseConfig.Cluster
+	parameters := request.Parameters
+	if value, ok := parameters[clusterParameter]; ok {
+		requested, _ := value.(string)
+		if name != "" && requested != name {
+			description := fmt.Sprintf("the plan is pinned to cluster %q", name)
+			return nil, nil, osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusBadRequest,
+				Description: &description,
+			}
+		}
+		name = requested
+		parameters = make(map[string]interface{}, len(request.Parameters))
+		for k, v := range request.Parameters {
+			if k != clusterParameter {
+				parameters[k] = v
+			}
+		}
+	}
+
+	if name != "" {
+		c, ok := b.clusters[name]
+		if !ok {
+			description := fmt.Sprintf("unknown cluster %q", name)
+			return nil, nil, osb.HTTPStatusCodeError{
+				StatusCode:  http.StatusBadRequest,
+				Description: &description,
+			}
+		}
+		return c, parameters, nil
+	}
+
+	if clusterID, ok := request.Context["clusterid"].(string); ok && clusterID != "" {
+		for name, clusterConfig := range b.config.Clusters {
+			if clusterConfig.ClusterID == clusterID || (clusterConfig.ClusterID == "" && name == clusterID) {
+				return b.clusters[name], parameters, nil
+			}
+		}
+	}
+	return b.clusters[""], parameters, nil
+}
+
+// getRelease returns the release of the service instance with the instance ID.
+// All namespaces are searched if the namespace is empty.
+func (b *HelmBroker) getRelease(namespace string, instanceID string) (*releaseRef, error) {
+	instance, err := b.getInstance(namespace, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return b.getInstanceRelease(instance)
+}
+
+// getInstanceRelease returns the release of a service instance, which has the
+// name and namespace of the instance and is held by the cluster recorded in
+// the placement of the instance. Instances without a placement are held by the
+// cluster the broker runs in.
+func (b *HelmBroker) getInstanceRelease(instance *v1beta1.ServiceInstance) (*releaseRef, error) {
+	var name string
+	placement, err := b.store.GetPlacement(instance.Spec.ExternalID)
+	if err == nil {
+		name = placement.Cluster
+	} else if err != store.ErrNotFound {
+		return nil, err
+	}
+
+	c, ok := b.clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster %q of instance %s", name, instance.Spec.ExternalID)
+	}
+	return &releaseRef{cluster: c, namespace: instance.Namespace, name: instance.Name}, nil
+}
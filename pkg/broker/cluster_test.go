@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+)
+
+func TestValidateRemoteTillers(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		tillers map[string]TillerConfig
+		err     bool
+	}{
+		{name: "local backend", options: Options{Backend: "local"}},
+		{name: "tiller host", options: Options{Backend: "tiller", TillerHost: "tiller.example.com:44134"}},
+		{name: "discovered tiller", options: Options{Backend: "tiller"}, err: true},
+		{
+			name:    "tiller hosts of namespaces",
+			options: Options{Backend: "tiller", TillerHost: "tiller.example.com:44134"},
+			tillers: map[string]TillerConfig{"team-a": {Host: "tiller.team-a.example.com:44134"}},
+		},
+		{
+			name:    "discovered tiller of namespace",
+			options: Options{Backend: "tiller", TillerHost: "tiller.example.com:44134"},
+			tillers: map[string]TillerConfig{"team-a": {Namespace: "team-a-tiller"}},
+			err:     true,
+		},
+		{
+			name:    "tiller per namespace",
+			options: Options{Backend: "tiller", TillerHost: "tiller.example.com:44134", TillerPerNamespace: true},
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateRemoteTillers(test.options, test.tillers)
+			if test.err && err == nil {
+				t.Error("expected error")
+			} else if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateRegistryRewrites(t *testing.T) {
+	mirrors := helm.RegistryRewrites{
+		{From: "docker.io", To: "mirror.example.com/dockerhub"},
+		{From: "quay.io", To: "mirror.example.com/quay"},
+	}
+	tests := []struct {
+		name     string
+		options  Options
+		rewrites helm.RegistryRewrites
+		err      bool
+	}{
+		{name: "no rules", options: Options{Backend: "tiller"}},
+		{name: "local backend", options: Options{Backend: "local"}, rewrites: mirrors},
+		{name: "different mirrors", options: Options{Backend: "tiller"}, rewrites: mirrors, err: true},
+		{name: "default backend", rewrites: mirrors, err: true},
+		{
+			name:     "default registry not rewritten",
+			options:  Options{Backend: "tiller"},
+			rewrites: helm.RegistryRewrites{{From: "quay.io", To: "mirror.example.com"}},
+			err:      true,
+		},
+		{
+			name:     "single mirror",
+			options:  Options{Backend: "tiller"},
+			rewrites: helm.RegistryRewrites{{From: "*", To: "mirror.example.com"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateRegistryRewrites(test.options, test.rewrites)
+			if test.err && err == nil {
+				t.Error("expected error")
+			} else if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
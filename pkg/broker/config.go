@@ -0,0 +1,327 @@
+package broker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Config holds the configuration of the broker loaded from the config file.
+type Config struct {
+	// Charts holds the settings of charts keyed by chart name, e.g. "stable/mysql".
+	Charts map[string]ChartConfig `json:"charts,omitempty"`
+	// Tillers holds the Tillers serving namespaces keyed by namespace.
+	// Releases in other namespaces are managed by the default Tiller.
+	Tillers map[string]TillerConfig `json:"tillers,omitempty"`
+	// Clusters holds the target clusters releases may be installed in keyed
+	// by cluster name, in addition to the cluster the broker runs in.
+	Clusters map[string]ClusterConfig `json:"clusters,omitempty"`
+	// RegistryRewrites are the rules rewriting the registries of the images
+	// of releases to mirrors. Releases installed by Tiller are only rewritten
+	// through the global.imageRegistry value, since charts pull the images of
+	// all registries from it, so the Tiller backend requires all the rules to
+	// rewrite to the same mirror and a rule matching docker.io.
+	RegistryRewrites helm.RegistryRewrites `json:"registryRewrites,omitempty"`
+	// GitSources holds the Git repositories charts are served from keyed by
+	// the name the charts are referenced with, e.g. "platform/mychart".
+	GitSources map[string]GitSourceConfig `json:"gitSources,omitempty"`
+}
+
+// GitSourceConfig holds the settings of a Git repository charts are served
+// from.
+type GitSourceConfig struct {
+	// Repository is the path of a local checkout or bare repository.
+	Repository string `json:"repository"`
+	// Ref is the branch, tag or commit the charts are read at. It defaults
+	// to HEAD.
+	Ref string `json:"ref,omitempty"`
+	// Path is the directory of the repository the charts are found in. It
+	// defaults to the root of the repository.
+	Path string `json:"path,omitempty"`
+	// PollInterval in seconds in which new commits are picked up.
+	PollInterval *int64 `json:"pollInterval,omitempty"`
+}
+
+// defaultGitPollInterval is the default interval in seconds in which new
+// commits of Git sources are picked up.
+const defaultGitPollInterval = 60
+
+// ref returns the ref the charts of the source are read at.
+func (g GitSourceConfig) ref() string {
+	if g.Ref == "" {
+		return "HEAD"
+	}
+	return g.Ref
+}
+
+// pollInterval returns the interval in which new commits are picked up.
+func (g GitSourceConfig) pollInterval() time.Duration {
+	interval := int64(defaultGitPollInterval)
+	if g.PollInterval != nil && *g.PollInterval > 0 {
+		interval = *g.PollInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// ClusterConfig holds the connection settings of a target cluster.
+type ClusterConfig struct {
+	// ClusterID is the ID of the cluster in the context of requests from
+	// the platform. Requests are routed to the cluster by name if it is
+	// empty.
+	ClusterID string `json:"clusterID,omitempty"`
+	// Kubeconfig is the path to the kubeconfig file of the cluster.
+	Kubeconfig string `json:"kubeconfig"`
+	// Context is the kubeconfig context of the cluster. The current context
+	// is used if it is empty.
+	Context string `json:"context,omitempty"`
+	// Backend managing the releases in the cluster, either "tiller" or
+	// "local". It defaults to the backend of the broker.
+	Backend string `json:"backend,omitempty"`
+	// TillerHost is the host and port of the default Tiller of the cluster,
+	// which must be reachable from the broker. It is required with the
+	// Tiller backend, since Tillers are only discovered in the cluster the
+	// broker runs in.
+	TillerHost string `json:"tillerHost,omitempty"`
+	// Tillers holds the Tillers serving namespaces of the cluster keyed by
+	// namespace. Their hosts are required like the Tiller host.
+	Tillers map[string]TillerConfig `json:"tillers,omitempty"`
+	// RegistryRewrites are the rules rewriting the registries of the images
+	// of releases in the cluster. They override the rules of the broker.
+	RegistryRewrites helm.RegistryRewrites `json:"registryRewrites,omitempty"`
+}
+
+// TillerConfig holds the location of the Tiller serving a namespace.
+type TillerConfig struct {
+	// Host is the host and port of Tiller.
+	Host string `json:"host,omitempty"`
+	// Namespace is the namespace Tiller is discovered in if the host is
+	// empty. It defaults to the namespace served by Tiller.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ChartConfig holds the settings of a chart.
+type ChartConfig struct {
+	ReleaseConfig
+	// ServiceID overrides the ID of the service of the chart, e.g. to keep
+	// the ID the service class of the chart was registered with.
+	ServiceID string `json:"serviceID,omitempty"`
+	// Bindable marks the service of the chart as bindable. It overrides the
+	// bindable annotation of the chart.
+	Bindable *bool `json:"bindable,omitempty"`
+	// BindingTemplate is a Go template rendered into the binding credentials,
+	// with the Sprig functions available to chart templates.
+	// It overrides the binding template annotation of the chart.
+	BindingTemplate string `json:"bindingTemplate,omitempty"`
+	// BindJob is a template of a Job which creates a dedicated user for each
+	// binding. It overrides the bind job annotation of the chart. Bindings
+	// of charts with a bind job are created and deleted asynchronously.
+	BindJob string `json:"bindJob,omitempty"`
+	// UnbindJob is a template of a Job which revokes the user created by the
+	// bind job. It overrides the unbind job annotation of the chart.
+	UnbindJob string `json:"unbindJob,omitempty"`
+	// Timeout in seconds for bind and unbind jobs.
+	BindTimeout *int64 `json:"bindTimeout,omitempty"`
+	// FleetUpgrade holds the default settings of fleet upgrades of the
+	// instances of the chart.
+	FleetUpgrade FleetUpgradeConfig `json:"fleetUpgrade,omitempty"`
+	// Plans holds the plans advertised for the chart. A default plan is
+	// advertised if it is empty.
+	Plans []PlanConfig `json:"plans,omitempty"`
+}
+
+// PlanConfig holds the settings of a plan. Settings of a plan override the
+// settings of its chart.
+type PlanConfig struct {
+	ReleaseConfig
+	// Name of the plan.
+	Name string `json:"name"`
+	// Description of the plan.
+	Description string `json:"description,omitempty"`
+	// Values are the default values for releases installed with the plan.
+	Values map[string]interface{} `json:"values,omitempty"`
+	// ChartVersion is the version of the chart installed with the plan, which
+	// is advertised as the maintenance version of the plan. The latest
+	// version is installed if it is empty.
+	ChartVersion string `json:"chartVersion,omitempty"`
+	// UpdatableTo holds the names of the plans instances of the plan may be
+	// updated to.
+	UpdatableTo []string `json:"updatableTo,omitempty"`
+}
+
+// FleetUpgradeConfig holds the settings of fleet upgrades.
+type FleetUpgradeConfig struct {
+	// CanarySize is the number of instances upgraded in each wave until an
+	// instance was upgraded successfully.
+	CanarySize int `json:"canarySize,omitempty"`
+	// BatchSize is the number of instances upgraded in each following wave.
+	BatchSize int `json:"batchSize,omitempty"`
+}
+
+// ReleaseConfig holds the settings of release operations.
+type ReleaseConfig struct {
+	// Timeout in seconds for installing a release.
+	InstallTimeout *int64 `json:"installTimeout,omitempty"`
+	// Timeout in seconds for upgrading a release.
+	UpgradeTimeout *int64 `json:"upgradeTimeout,omitempty"`
+	// Timeout in seconds for deleting a release.
+	DeleteTimeout *int64 `json:"deleteTimeout,omitempty"`
+	// Wait until all workloads of a release are ready before an install or
+	// upgrade reports success.
+	Wait *bool `json:"wait,omitempty"`
+	// RollbackOnFailure rolls a release back to its last deployed revision
+	// if an upgrade fails or times out.
+	RollbackOnFailure *bool `json:"rollbackOnFailure,omitempty"`
+	// UpdateValues chooses whether updates reuse the values of a release
+	// ("reuse") or reset them to the plan values ("reset"). Updates reuse
+	// the values by default.
+	UpdateValues string `json:"updateValues,omitempty"`
+	// Cluster is the name of the target cluster releases are installed in.
+	// Releases are installed in the cluster chosen by the cluster parameter
+	// or the context of the provision request, or the cluster the broker
+	// runs in, if it is empty.
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// LoadConfig loads the config file of the broker. An empty config is returned
+// if the path is empty.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// chart returns the settings of a chart.
+func (c *Config) chart(name string) ChartConfig {
+	return c.Charts[name]
+}
+
+// plan returns the plan of the chart with the plan ID, or nil if the plan ID
+// refers to the default plan.
+func (c ChartConfig) plan(serviceID string, planID string) *PlanConfig {
+	for i, plan := range c.Plans {
+		if getPlanID(serviceID, plan.Name) == planID {
+			return &c.Plans[i]
+		}
+	}
+	return nil
+}
+
+// planUpdatable returns true if instances of any plan of the chart may be
+// updated to another plan.
+func (c ChartConfig) planUpdatable() bool {
+	for _, plan := range c.Plans {
+		if len(plan.UpdatableTo) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePlanUpdate returns an error if instances of a plan may not be updated
+// to another plan.
+func (c ChartConfig) validatePlanUpdate(serviceID string, fromPlanID string, toPlanID string) error {
+	from, to := c.plan(serviceID, fromPlanID), c.plan(serviceID, toPlanID)
+	if from != nil && to != nil {
+		for _, name := range from.UpdatableTo {
+			if name == to.Name {
+				return nil
+			}
+		}
+	}
+
+	description := fmt.Sprintf("plan %s may not be updated to plan %s", fromPlanID, toPlanID)
+	return osb.HTTPStatusCodeError{
+		StatusCode:  http.StatusBadRequest,
+		Description: &description,
+	}
+}
+
+// configOrAnnotation returns the configured value if it is set, or the value of the
+// chart annotation otherwise.
+func configOrAnnotation(value string, annotations map[string]string, annotation string) string {
+	if value != "" {
+		return value
+	}
+	return annotations[annotation]
+}
+
+// release returns the release settings of the chart overridden by the plan.
+func (c ChartConfig) release(serviceID string, planID string) ReleaseConfig {
+	config := c.ReleaseConfig
+	plan := c.plan(serviceID, planID)
+	if plan == nil {
+		return config
+	}
+
+	if plan.InstallTimeout != nil {
+		config.InstallTimeout = plan.InstallTimeout
+	}
+	if plan.UpgradeTimeout != nil {
+		config.UpgradeTimeout = plan.UpgradeTimeout
+	}
+	if plan.DeleteTimeout != nil {
+		config.DeleteTimeout = plan.DeleteTimeout
+	}
+	if plan.Wait != nil {
+		config.Wait = plan.Wait
+	}
+	if plan.RollbackOnFailure != nil {
+		config.RollbackOnFailure = plan.RollbackOnFailure
+	}
+	if plan.UpdateValues != "" {
+		config.UpdateValues = plan.UpdateValues
+	}
+	if plan.Cluster != "" {
+		config.Cluster = plan.Cluster
+	}
+	return config
+}
+
+// installOptions returns the options for installing a release.
+func (r ReleaseConfig) installOptions() helm.ReleaseOptions {
+	return r.options(r.InstallTimeout)
+}
+
+// upgradeOptions returns the options for upgrading a release.
+func (r ReleaseConfig) upgradeOptions() helm.ReleaseOptions {
+	return r.options(r.UpgradeTimeout)
+}
+
+// deleteOptions returns the options for deleting a release.
+func (r ReleaseConfig) deleteOptions() helm.ReleaseOptions {
+	return r.options(r.DeleteTimeout)
+}
+
+// rollbackOnFailure returns true if failed upgrades are rolled back.
+func (r ReleaseConfig) rollbackOnFailure() bool {
+	return r.RollbackOnFailure != nil && *r.RollbackOnFailure
+}
+
+func (r ReleaseConfig) options(timeout *int64) helm.ReleaseOptions {
+	opts := helm.ReleaseOptions{
+		Timeout: helm.DefaultTimeout,
+	}
+	if timeout != nil {
+		opts.Timeout = *timeout
+	}
+	if r.Wait != nil {
+		opts.Wait = *r.Wait
+	}
+	return opts
+}
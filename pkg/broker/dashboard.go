@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"github.com/golang/glog"
+	"github.com/huangjiuyuan/helm-broker/pkg/kube"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// dashboardURLAnnotation is the chart annotation holding a template of the
+// dashboard URL of instances, e.g.
+// "https://{{ (index .Ingresses 0).Host }}/admin".
+const dashboardURLAnnotation = "helm-broker.io/dashboard-url"
+
+// dashboardData is the data the dashboard URL template is rendered with.
+type dashboardData struct {
+	kube.Addresses
+	// Release holds the name and namespace of the release.
+	Release struct {
+		Name      string
+		Namespace string
+	}
+	// Values holds the values supplied to the release.
+	Values map[string]interface{}
+}
+
+// getDashboardURL returns the dashboard URL of a release, which is rendered
+// from the dashboard URL annotation of its chart, or derived from the hosts of
+// its Ingresses or the addresses of its LoadBalancer Services. An empty string
+// is returned if the resources of the release have no addresses yet.
+func (b *HelmBroker) getDashboardURL(ctx context.Context, ref *releaseRef) (string, error) {
+	name := ref.name
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return "", err
+	}
+	content, err := helmClient.ReleaseContent(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	rel := content.GetRelease()
+
+	resources, err := kube.ParseManifest(rel.GetManifest())
+	if err != nil {
+		return "", err
+	}
+	addresses, err := kube.GetAddresses(ref.cluster.kubeClient, rel.GetNamespace(), resources)
+	if err != nil {
+		return "", err
+	}
+
+	text, ok := rel.GetChart().GetMetadata().GetAnnotations()[dashboardURLAnnotation]
+	if !ok {
+		return addresses.URL(), nil
+	}
+
+	values, err := chartutil.ReadValues([]byte(rel.GetConfig().GetRaw()))
+	if err != nil {
+		return "", fmt.Errorf("failed to read values of release %s: %v", name, err)
+	}
+	data := dashboardData{
+		Addresses: *addresses,
+		Values:    values,
+	}
+	data.Release.Name = rel.GetName()
+	data.Release.Namespace = rel.GetNamespace()
+
+	tmpl, err := template.New(dashboardURLAnnotation).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse dashboard URL template of release %s: %v", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		// The template most likely refers to an address which is not
+		// assigned yet.
+		glog.V(4).Infof("failed to render dashboard URL of release %s: %v", name, err)
+		return "", nil
+	}
+
+	return buf.String(), nil
+}
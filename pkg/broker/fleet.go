@@ -0,0 +1,392 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/huangjiuyuan/helm-broker/pkg/rest"
+	"github.com/kubernetes-incubator/service-catalog/pkg/apis/servicecatalog/v1beta1"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// defaultCanarySize is the default number of instances upgraded in each
+	// wave until an instance was upgraded successfully.
+	defaultCanarySize = 1
+	// defaultBatchSize is the default number of instances upgraded in each
+	// following wave.
+	defaultBatchSize = 5
+)
+
+// States of fleet upgrades.
+const (
+	fleetRunning   = "running"
+	fleetPaused    = "paused"
+	fleetCompleted = "completed"
+)
+
+// States of the instances of fleet upgrades.
+const (
+	instancePending   = "pending"
+	instanceUpgrading = "upgrading"
+	instanceUpgraded  = "upgraded"
+	instanceFailed    = "failed"
+)
+
+// fleet holds the fleet upgrades of the broker by service ID. Fleet upgrades
+// are kept in memory and do not survive a restart of the broker.
+type fleet struct {
+	mutex    sync.Mutex
+	upgrades map[string]*fleetUpgrade
+}
+
+func newFleet() *fleet {
+	return &fleet{
+		upgrades: map[string]*fleetUpgrade{},
+	}
+}
+
+// fleetUpgrade upgrades all instances of a service in waves. The first waves
+// canary the upgrade on a few instances, and each wave starts only if all
+// instances of the previous waves were upgraded successfully.
+type fleetUpgrade struct {
+	mutex          sync.Mutex
+	status         rest.FleetUpgradeStatus
+	planIDs        map[string]string
+	canarySize     int
+	batchSize      int
+	pauseRequested bool
+	metrics        *MetricsCollector
+}
+
+// StartFleetUpgrade starts upgrading all instances of a service.
+func (b *HelmBroker) StartFleetUpgrade(request *rest.FleetUpgradeRequest, c *broker.RequestContext) (*rest.FleetUpgradeStatus, error) {
+	if request.ServiceID == "" {
+		description := "service_id is required"
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusBadRequest,
+			Description: &description,
+		}
+	}
+
+	chart, err := b.getChart(request.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	fleetConfig := b.config.chart(chart).FleetUpgrade
+
+	upgrade := &fleetUpgrade{
+		status: rest.FleetUpgradeStatus{
+			ServiceID:    request.ServiceID,
+			ChartVersion: request.ChartVersion,
+			State:        fleetRunning,
+		},
+		planIDs:    map[string]string{},
+		canarySize: firstPositive(request.CanarySize, fleetConfig.CanarySize, defaultCanarySize),
+		batchSize:  firstPositive(request.BatchSize, fleetConfig.BatchSize, defaultBatchSize),
+		metrics:    b.metrics,
+	}
+
+	instances, err := b.getServiceInstances(request.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		upgrade.status.Instances = append(upgrade.status.Instances, rest.FleetUpgradeInstance{
+			InstanceID: instance.Spec.ExternalID,
+			Name:       instance.Name,
+			Namespace:  instance.Namespace,
+			State:      instancePending,
+		})
+		if instance.Spec.ClusterServicePlanRef != nil {
+			upgrade.planIDs[instance.Spec.ExternalID] = instance.Spec.ClusterServicePlanRef.Name
+		}
+	}
+
+	b.fleet.mutex.Lock()
+	if current, ok := b.fleet.upgrades[request.ServiceID]; ok && current.state() == fleetRunning {
+		b.fleet.mutex.Unlock()
+		description := fmt.Sprintf("service %s has a running fleet upgrade", request.ServiceID)
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusConflict,
+			Description: &description,
+		}
+	}
+	b.fleet.upgrades[request.ServiceID] = upgrade
+	b.fleet.mutex.Unlock()
+
+	glog.Infof("starting fleet upgrade of %d instances of service %s", len(instances), request.ServiceID)
+	upgrade.updateMetrics()
+	go b.runFleetUpgrade(upgrade)
+
+	return upgrade.snapshot(), nil
+}
+
+// GetFleetUpgrade returns the progress of the fleet upgrade of a service.
+func (b *HelmBroker) GetFleetUpgrade(serviceID string, c *broker.RequestContext) (*rest.FleetUpgradeStatus, error) {
+	upgrade, err := b.getFleetUpgrade(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	return upgrade.snapshot(), nil
+}
+
+// PauseFleetUpgrade pauses the fleet upgrade of a service after its current
+// wave.
+func (b *HelmBroker) PauseFleetUpgrade(serviceID string, c *broker.RequestContext) (*rest.FleetUpgradeStatus, error) {
+	upgrade, err := b.getFleetUpgrade(serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	upgrade.mutex.Lock()
+	if upgrade.status.State == fleetRunning {
+		upgrade.pauseRequested = true
+	}
+	upgrade.mutex.Unlock()
+
+	return upgrade.snapshot(), nil
+}
+
+// ResumeFleetUpgrade resumes a paused fleet upgrade of a service, retrying the
+// instances whose upgrade failed.
+func (b *HelmBroker) ResumeFleetUpgrade(serviceID string, c *broker.RequestContext) (*rest.FleetUpgradeStatus, error) {
+	upgrade, err := b.getFleetUpgrade(serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !upgrade.resume() {
+		description := fmt.Sprintf("fleet upgrade of service %s is not paused", serviceID)
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusConflict,
+			Description: &description,
+		}
+	}
+
+	glog.Infof("resuming fleet upgrade of service %s", serviceID)
+	upgrade.updateMetrics()
+	go b.runFleetUpgrade(upgrade)
+
+	return upgrade.snapshot(), nil
+}
+
+// getFleetUpgrade returns the fleet upgrade of a service.
+func (b *HelmBroker) getFleetUpgrade(serviceID string) (*fleetUpgrade, error) {
+	b.fleet.mutex.Lock()
+	defer b.fleet.mutex.Unlock()
+
+	upgrade, ok := b.fleet.upgrades[serviceID]
+	if !ok {
+		description := fmt.Sprintf("service %s has no fleet upgrade", serviceID)
+		return nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusNotFound,
+			Description: &description,
+		}
+	}
+	return upgrade, nil
+}
+
+// getServiceInstances returns the instances of a service in all namespaces,
+// ordered by namespace and name.
+func (b *HelmBroker) getServiceInstances(serviceID string) ([]v1beta1.ServiceInstance, error) {
+	instanceList, err := b.svcatClient.ServicecatalogV1beta1().ServiceInstances("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []v1beta1.ServiceInstance
+	for _, instance := range instanceList.Items {
+		if instance.DeletionTimestamp != nil || instance.Spec.ClusterServiceClassRef == nil {
+			continue
+		}
+		if instance.Spec.ClusterServiceClassRef.Name == serviceID {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Namespace != instances[j].Namespace {
+			return instances[i].Namespace < instances[j].Namespace
+		}
+		return instances[i].Name < instances[j].Name
+	})
+	return instances, nil
+}
+
+// runFleetUpgrade upgrades the instances of a fleet upgrade wave by wave, until
+// all instances are upgraded or the upgrade is paused.
+func (b *HelmBroker) runFleetUpgrade(upgrade *fleetUpgrade) {
+	for {
+		wave := upgrade.nextWave()
+		if wave == nil {
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, i := range wave {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				upgrade.finish(i, b.upgradeFleetInstance(upgrade, i))
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+// upgradeFleetInstance upgrades an instance of a fleet upgrade, keeping its
+// plan and values. The values of the release are reused regardless of the
+// update semantics configured for the chart, since the upgrade carries no
+// parameters of its own.
+func (b *HelmBroker) upgradeFleetInstance(upgrade *fleetUpgrade, i int) error {
+	upgrade.mutex.Lock()
+	instance := upgrade.status.Instances[i]
+	planID := upgrade.planIDs[instance.InstanceID]
+	request := &rest.UpdateInstanceRequest{
+		UpdateInstanceRequest: osb.UpdateInstanceRequest{
+			InstanceID:     instance.InstanceID,
+			ServiceID:      upgrade.status.ServiceID,
+			PreviousValues: &osb.PreviousValues{PlanID: planID},
+			Parameters:     map[string]interface{}{updateValuesParameter: reuseValues},
+		},
+	}
+	chartVersion := upgrade.status.ChartVersion
+	upgrade.mutex.Unlock()
+
+	update, err := b.getReleaseUpdate(b.ctx, request, instance.Namespace)
+	if err != nil {
+		return err
+	}
+	if chartVersion != "" {
+		update.opts.ChartVersion = chartVersion
+	}
+
+	if _, err := b.upgradeRelease(b.ctx, instance.InstanceID, update); err != nil {
+		return err
+	}
+	glog.Infof("fleet upgrade of service %s upgraded release %s", request.ServiceID, update.release.name)
+	return nil
+}
+
+// nextWave returns the indexes of the instances of the next wave and marks
+// them as upgrading, or returns nil if the upgrade is paused or completed.
+func (u *fleetUpgrade) nextWave() []int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.pauseRequested {
+		u.status.State = fleetPaused
+		glog.Infof("fleet upgrade of service %s paused", u.status.ServiceID)
+		return nil
+	}
+
+	size := u.canarySize
+	var wave []int
+	for _, instance := range u.status.Instances {
+		if instance.State == instanceUpgraded {
+			size = u.batchSize
+		}
+	}
+	for i, instance := range u.status.Instances {
+		if instance.State == instancePending && len(wave) < size {
+			u.status.Instances[i].State = instanceUpgrading
+			wave = append(wave, i)
+		}
+	}
+	if len(wave) == 0 {
+		u.status.State = fleetCompleted
+		glog.Infof("fleet upgrade of service %s completed", u.status.ServiceID)
+		return nil
+	}
+
+	u.status.Waves++
+	return wave
+}
+
+// finish records the result of the upgrade of an instance. The upgrade is
+// paused after the current wave if the instance failed.
+func (u *fleetUpgrade) finish(i int, err error) {
+	u.mutex.Lock()
+	instance := &u.status.Instances[i]
+	result := instanceUpgraded
+	if err != nil {
+		glog.Errorf("fleet upgrade of service %s failed to upgrade instance %s: %v", u.status.ServiceID, instance.InstanceID, err)
+		result = instanceFailed
+		instance.Description = err.Error()
+		u.pauseRequested = true
+	}
+	instance.State = result
+	u.mutex.Unlock()
+
+	u.metrics.FleetUpgrades.WithLabelValues(u.status.ServiceID, result).Inc()
+	u.updateMetrics()
+}
+
+// resume resumes a paused fleet upgrade, resetting the instances whose upgrade
+// failed to pending. It returns false if the upgrade is not paused.
+func (u *fleetUpgrade) resume() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.status.State != fleetPaused {
+		return false
+	}
+	for i := range u.status.Instances {
+		if u.status.Instances[i].State == instanceFailed {
+			u.status.Instances[i].State = instancePending
+			u.status.Instances[i].Description = ""
+		}
+	}
+	u.status.State = fleetRunning
+	u.pauseRequested = false
+	return true
+}
+
+// state returns the state of the fleet upgrade.
+func (u *fleetUpgrade) state() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.status.State
+}
+
+// snapshot returns a copy of the progress of the fleet upgrade.
+func (u *fleetUpgrade) snapshot() *rest.FleetUpgradeStatus {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	status := u.status
+	status.Instances = append([]rest.FleetUpgradeInstance(nil), u.status.Instances...)
+	return &status
+}
+
+// updateMetrics sets the number of instances of the fleet upgrade by state.
+func (u *fleetUpgrade) updateMetrics() {
+	status := u.snapshot()
+	counts := map[string]int{
+		instancePending:   0,
+		instanceUpgrading: 0,
+		instanceUpgraded:  0,
+		instanceFailed:    0,
+	}
+	for _, instance := range status.Instances {
+		counts[instance.State]++
+	}
+	for state, count := range counts {
+		u.metrics.FleetUpgradeInstances.WithLabelValues(status.ServiceID, state).Set(float64(count))
+	}
+}
+
+// firstPositive returns the first positive value.
+func firstPositive(values ...int) int {
+	for _, value := range values {
+		if value > 0 {
+			return value
+		}
+	}
+	return 0
+}
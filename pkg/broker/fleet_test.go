@@ -0,0 +1,164 @@
+package broker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/huangjiuyuan/helm-broker/pkg/rest"
+)
+
+func newTestFleetUpgrade(states ...string) *fleetUpgrade {
+	upgrade := &fleetUpgrade{
+		status:     rest.FleetUpgradeStatus{ServiceID: "service", State: fleetRunning},
+		canarySize: 1,
+		batchSize:  2,
+		metrics:    NewMetricsCollector(),
+	}
+	for _, state := range states {
+		upgrade.status.Instances = append(upgrade.status.Instances, rest.FleetUpgradeInstance{State: state})
+	}
+	return upgrade
+}
+
+func instanceStates(upgrade *fleetUpgrade) []string {
+	var states []string
+	for _, instance := range upgrade.snapshot().Instances {
+		states = append(states, instance.State)
+	}
+	return states
+}
+
+func TestFleetUpgradeNextWave(t *testing.T) {
+	tests := []struct {
+		name           string
+		states         []string
+		pauseRequested bool
+		wave           []int
+		state          string
+	}{
+		{
+			name:   "canary",
+			states: []string{instancePending, instancePending, instancePending},
+			wave:   []int{0},
+			state:  fleetRunning,
+		},
+		{
+			name:   "canary until an instance is upgraded",
+			states: []string{instanceFailed, instancePending, instancePending},
+			wave:   []int{1},
+			state:  fleetRunning,
+		},
+		{
+			name:   "batch after an instance is upgraded",
+			states: []string{instanceUpgraded, instancePending, instancePending, instancePending},
+			wave:   []int{1, 2},
+			state:  fleetRunning,
+		},
+		{
+			name:           "paused",
+			states:         []string{instanceUpgraded, instancePending},
+			pauseRequested: true,
+			state:          fleetPaused,
+		},
+		{
+			name:   "completed",
+			states: []string{instanceUpgraded, instanceUpgraded},
+			state:  fleetCompleted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upgrade := newTestFleetUpgrade(test.states...)
+			upgrade.pauseRequested = test.pauseRequested
+
+			wave := upgrade.nextWave()
+			if !reflect.DeepEqual(wave, test.wave) {
+				t.Errorf("expected wave %v, got %v", test.wave, wave)
+			}
+			if state := upgrade.state(); state != test.state {
+				t.Errorf("expected state %s, got %s", test.state, state)
+			}
+			for _, i := range wave {
+				if state := instanceStates(upgrade)[i]; state != instanceUpgrading {
+					t.Errorf("expected instance %d to be upgrading, got %s", i, state)
+				}
+			}
+		})
+	}
+}
+
+func TestFleetUpgradeWaves(t *testing.T) {
+	upgrade := newTestFleetUpgrade(instancePending, instancePending, instancePending, instancePending, instancePending)
+
+	// The canary fails, which pauses the upgrade after the wave.
+	wave := upgrade.nextWave()
+	if !reflect.DeepEqual(wave, []int{0}) {
+		t.Fatalf("expected canary wave [0], got %v", wave)
+	}
+	upgrade.finish(0, errors.New("upgrade failed"))
+	if wave := upgrade.nextWave(); wave != nil {
+		t.Fatalf("expected upgrade to pause after a failure, got wave %v", wave)
+	}
+	if state := upgrade.state(); state != fleetPaused {
+		t.Fatalf("expected state %s, got %s", fleetPaused, state)
+	}
+	if description := upgrade.snapshot().Instances[0].Description; description != "upgrade failed" {
+		t.Errorf("expected failure to be described, got %q", description)
+	}
+
+	// Resuming retries the failed instance as a canary.
+	if !upgrade.resume() {
+		t.Fatal("expected paused upgrade to resume")
+	}
+	if upgrade.resume() {
+		t.Error("expected running upgrade not to resume")
+	}
+	expected := []string{instancePending, instancePending, instancePending, instancePending, instancePending}
+	if states := instanceStates(upgrade); !reflect.DeepEqual(states, expected) {
+		t.Fatalf("expected states %v after resume, got %v", expected, states)
+	}
+	if description := upgrade.snapshot().Instances[0].Description; description != "" {
+		t.Errorf("expected failure description to be reset, got %q", description)
+	}
+	wave = upgrade.nextWave()
+	if !reflect.DeepEqual(wave, []int{0}) {
+		t.Fatalf("expected canary wave [0] after resume, got %v", wave)
+	}
+	upgrade.finish(0, nil)
+
+	// The following waves are batches.
+	for _, expected := range [][]int{{1, 2}, {3, 4}} {
+		wave := upgrade.nextWave()
+		if !reflect.DeepEqual(wave, expected) {
+			t.Fatalf("expected batch wave %v, got %v", expected, wave)
+		}
+		for _, i := range wave {
+			upgrade.finish(i, nil)
+		}
+	}
+	if wave := upgrade.nextWave(); wave != nil {
+		t.Fatalf("expected upgrade to complete, got wave %v", wave)
+	}
+	if state := upgrade.state(); state != fleetCompleted {
+		t.Errorf("expected state %s, got %s", fleetCompleted, state)
+	}
+	if waves := upgrade.snapshot().Waves; waves != 4 {
+		t.Errorf("expected 4 waves, got %d", waves)
+	}
+}
+
+func TestFleetUpgradeReusesValues(t *testing.T) {
+	parameters := map[string]interface{}{updateValuesParameter: reuseValues}
+	mode, stripped, err := getUpdateMode(parameters, ReleaseConfig{UpdateValues: resetValues})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mode != reuseValues {
+		t.Errorf("expected mode %s, got %s", reuseValues, mode)
+	}
+	if len(stripped) != 0 {
+		t.Errorf("expected no parameters, got %v", stripped)
+	}
+}
@@ -0,0 +1,143 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/golang/glog"
+	"github.com/huangjiuyuan/helm-broker/pkg/rest"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// maskedValue replaces the values of secret parameters.
+const maskedValue = "******"
+
+// secretParameterPattern matches the names of parameters holding secret values.
+var secretParameterPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|apikey|privatekey)`)
+
+// instanceNotFoundError is returned if there is no service instance with the
+// instance ID.
+type instanceNotFoundError string
+
+func (e instanceNotFoundError) Error() string {
+	return fmt.Sprintf("failed to get name for instance %s", string(e))
+}
+
+// GetInstance returns the service ID, plan ID, dashboard URL and parameters of
+// an instance. The parameters are read from the config of its release, without
+// the values of its plan and with the values of secret parameters masked.
+// Parameters equal to a plan value cannot be told apart from it and are left
+// out as well.
+func (b *HelmBroker) GetInstance(request *rest.GetInstanceRequest, c *broker.RequestContext) (*rest.GetInstanceResponse, error) {
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
+
+	instance, err := b.getInstance("", request.InstanceID)
+	if _, ok := err.(instanceNotFoundError); ok {
+		return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusNotFound}
+	} else if err != nil {
+		return nil, err
+	}
+
+	ref, err := b.getInstanceRelease(instance)
+	if err != nil {
+		return nil, err
+	}
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	content, err := helmClient.ReleaseContent(ctx, instance.Name)
+	if err != nil {
+		if isReleaseNotFoundError(instance.Name, err) {
+			return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusNotFound}
+		}
+		return nil, err
+	}
+
+	values, err := chartutil.ReadValues([]byte(content.GetRelease().GetConfig().GetRaw()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values of release %s: %v", instance.Name, err)
+	}
+
+	response := rest.GetInstanceResponse{}
+	if instance.Spec.ClusterServiceClassRef != nil {
+		response.ServiceID = instance.Spec.ClusterServiceClassRef.Name
+	}
+	if instance.Spec.ClusterServicePlanRef != nil {
+		response.PlanID = instance.Spec.ClusterServicePlanRef.Name
+	}
+
+	// The release values hold the plan values the parameters were merged
+	// into, which are left out of the parameters.
+	parameters := map[string]interface{}(values)
+	if response.ServiceID != "" {
+		chart, err := b.getChart(response.ServiceID)
+		if err != nil {
+			return nil, err
+		}
+		if plan := b.config.chart(chart).plan(response.ServiceID, response.PlanID); plan != nil {
+			parameters = removeValues(parameters, plan.Values)
+		}
+	}
+	response.Parameters = maskSecretValues(parameters)
+
+	dashboardURL, err := b.getDashboardURL(ctx, ref)
+	if err != nil {
+		glog.Warningf("failed to get dashboard URL of release %s: %v", instance.Name, err)
+	} else if dashboardURL != "" {
+		response.DashboardURL = &dashboardURL
+	}
+
+	return &response, nil
+}
+
+// maskSecretValues returns a copy of the values with the values of secret
+// parameters masked, including those of tables in lists.
+func maskSecretValues(values map[string]interface{}) map[string]interface{} {
+	masked := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if secretParameterPattern.MatchString(k) {
+			masked[k] = maskedValue
+		} else {
+			masked[k] = maskSecretValue(v)
+		}
+	}
+	return masked
+}
+
+// maskSecretValue returns a copy of a value with the values of secret
+// parameters of the tables it holds masked.
+func maskSecretValue(v interface{}) interface{} {
+	if isTable(v) {
+		return maskSecretValues(toTable(v))
+	}
+	if list, ok := v.([]interface{}); ok {
+		masked := make([]interface{}, len(list))
+		for i, item := range list {
+			masked[i] = maskSecretValue(item)
+		}
+		return masked
+	}
+	return v
+}
+
+// isTable returns true if the value is a table of values.
+func isTable(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, chartutil.Values:
+		return true
+	}
+	return false
+}
+
+// toTable returns the value as a table of values.
+func toTable(v interface{}) map[string]interface{} {
+	if values, ok := v.(chartutil.Values); ok {
+		return values
+	}
+	return v.(map[string]interface{})
+}
@@ -1,25 +1,42 @@
 package broker
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
 	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/golang/glog"
 	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	"github.com/huangjiuyuan/helm-broker/pkg/kube"
+	"github.com/huangjiuyuan/helm-broker/pkg/rest"
+	"github.com/huangjiuyuan/helm-broker/pkg/store"
+	"github.com/kubernetes-incubator/service-catalog/pkg/apis/servicecatalog/v1beta1"
 	svcatclientset "github.com/kubernetes-incubator/service-catalog/pkg/client/clientset_generated/clientset"
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
 	"github.com/pmorie/osb-broker-lib/pkg/broker"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	kubeclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/proto/hapi/release"
 )
 
+// clusterChartRepository is the repository name of the charts stored in
+// ConfigMaps and Secrets of the broker namespace.
+const clusterChartRepository = "cluster"
+
 // NewHelmBroker is a hook that is called with the Options the program is run
 // with. NewHelmBroker is the place where you will initialize your
-// HelmBroker the parameters passed in.
-func NewHelmBroker(o Options) (*HelmBroker, error) {
+// HelmBroker the parameters passed in. Calls to Helm are cancelled when the
+// context is done.
+func NewHelmBroker(ctx context.Context, o Options) (*HelmBroker, error) {
 	var kubeconfig *string
 	if home := homedir.HomeDir(); home != "" {
 		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
@@ -45,113 +62,258 @@ func NewHelmBroker(o Options) (*HelmBroker, error) {
 		return nil, fmt.Errorf("failed to create service catalog client: %v", err)
 	}
 
+	if o.Namespace == "" {
+		if o.Namespace, err = getBrokerNamespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	brokerConfig, err := LoadConfig(o.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := NewMetricsCollector()
+	retryPolicy := helm.DefaultRetryPolicy
+	retryPolicy.Budget = o.HelmRetryBudget
+	retryPolicy.Observe = metrics.observeRetries
+	chartCacheDir := o.ChartCacheDir
+	if chartCacheDir == "" {
+		chartCacheDir = filepath.Join(helmpath.Home(o.HelmHome).Cache(), "charts")
+	}
+	chartCache := helm.NewChartCache(chartCacheDir, o.ChartCacheMaxSize, o.ChartCacheMaxAge)
+	var bundle *helm.Bundle
+	if o.ChartBundle != "" {
+		bundleCacheDir := filepath.Join(helmpath.Home(o.HelmHome).Cache(), "bundles")
+		if bundle, err = helm.OpenBundle(o.ChartBundle, bundleCacheDir); err != nil {
+			return nil, err
+		}
+	}
+	var sources []helm.ChartSource
+	if o.ChartSelector != "" {
+		sources = append(sources, helm.NewKubeChartSource(clusterChartRepository, kubeClient, o.Namespace, o.ChartSelector))
+	}
+	for name, gitConfig := range brokerConfig.GitSources {
+		source := helm.NewGitChartSource(name, gitConfig.Repository, gitConfig.ref(), gitConfig.Path, filepath.Join(helmpath.Home(o.HelmHome).Cache(), "git", name))
+		if err := source.Refresh(ctx); err != nil {
+			glog.Warningf("failed to refresh chart source %s: %v", name, err)
+		}
+		go source.Poll(ctx, gitConfig.pollInterval())
+		sources = append(sources, source)
+	}
+	configure := func(client *helm.Client) {
+		client.SetRetryPolicy(retryPolicy)
+		client.SetChartCache(chartCache)
+		client.SetBundle(bundle)
+		client.SetRegistryRewrites(brokerConfig.RegistryRewrites)
+		for _, source := range sources {
+			client.AddChartSource(source)
+		}
+	}
+
+	clusters, err := newClusters(o, brokerConfig, kubeClient, configure)
+	if err != nil {
+		return nil, err
+	}
+
 	return &HelmBroker{
+		ctx:         ctx,
 		async:       o.Async,
+		config:      brokerConfig,
 		kubeClient:  kubeClient,
 		svcatClient: svcatClient,
-		helmClient:  helm.NewClient(o.TillerHost, o.HelmHome),
+		brokerName:  o.BrokerName,
+		store:       store.NewStore(kubeClient, o.Namespace),
+		clusters:    clusters,
+		fleet:       newFleet(),
+		metrics:     metrics,
 		version:     "2.13",
 	}, nil
 }
 
 // HelmBroker provides an implementation of the broker.Interface.
 type HelmBroker struct {
+	// Root context of the broker, which is done when the broker shuts down.
+	ctx context.Context
 	// Indicates if the broker should handle the requests asynchronously.
 	async bool
+	// Configuration of charts and plans.
+	config *Config
 	// Clientset for kubernetes.
 	kubeClient kubeclientset.Interface
 	// Clientset for service catalog.
 	svcatClient svcatclientset.Interface
-	// Client for helm.
-	helmClient *helm.Client
+	// Name of the ClusterServiceBroker the broker is registered as.
+	brokerName string
+	// Store of bindings.
+	store *store.Store
+	// Clusters releases are installed in keyed by name, the cluster the
+	// broker runs in has an empty name.
+	clusters map[string]*cluster
+	// Fleet upgrades of the instances of services.
+	fleet *fleet
+	// Metrics of the broker.
+	metrics *MetricsCollector
 	// API version for broker.
 	version string
 }
 
-var _ broker.Interface = &HelmBroker{}
+var _ rest.Interface = &HelmBroker{}
+
+// Metrics returns the metrics collector of the broker.
+func (b *HelmBroker) Metrics() *MetricsCollector {
+	return b.metrics
+}
+
+// requestContext returns a context for the calls made for a request, which is
+// cancelled when the request ends or the broker shuts down.
+func (b *HelmBroker) requestContext(c *broker.RequestContext) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(b.ctx)
+	if c == nil || c.Request == nil {
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-c.Request.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
 
 // GetCatalog encapsulates the business logic for returning the broker's catalog of services.
 func (b *HelmBroker) GetCatalog(c *broker.RequestContext) (*broker.CatalogResponse, error) {
-	releases, err := b.helmClient.SearchReleases()
+	resp, err := b.GetExtendedCatalog(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get releases from Chart repositories")
+		return nil, err
 	}
 
-	response := &broker.CatalogResponse{}
-	services := make([]osb.Service, len(releases), len(releases))
-	for i, release := range releases {
-		serviceID, err := getServiceID(release.Chart.Digest)
-		if err != nil {
-			glog.Errorf("failed to get service ID for release %s: %v", release.Name, err)
-			continue
-		}
+	return &broker.CatalogResponse{CatalogResponse: *resp.ToCatalogResponse()}, nil
+}
+
+// GetExtendedCatalog returns the broker's catalog of services with the
+// maintenance versions of their plans.
+func (b *HelmBroker) GetExtendedCatalog(c *broker.RequestContext) (*rest.CatalogResponse, error) {
+	releases, err := b.clusters[""].helmClients.Default().SearchReleases()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get releases from Chart repositories")
+	}
 
+	legacyIDs, err := b.getLegacyServiceIDs()
+	if err != nil {
+		return nil, err
+	}
+	response := &rest.CatalogResponse{}
+	for _, release := range releases {
 		serviceName, err := getServiceName(release.Name)
 		if err != nil {
 			glog.Errorf("failed to get service name for release %s: %v", release.Name, err)
 			continue
 		}
 
-		service := osb.Service{
-			Name:        serviceName,
-			ID:          serviceID,
-			Description: release.Chart.Description,
-			Bindable:    false,
-			Plans: []osb.Plan{
-				{
+		chartConfig := b.config.chart(release.Name)
+		serviceID, legacyID := chartConfig.ServiceID, false
+		if serviceID == "" {
+			serviceID, legacyID = legacyIDs[serviceName]
+		}
+		if serviceID == "" {
+			serviceID = getServiceID(release.Name)
+		}
+		service := rest.Service{
+			Service: osb.Service{
+				Name:                serviceName,
+				ID:                  serviceID,
+				Description:         release.Chart.Description,
+				Bindable:            isBindable(release.Chart.Annotations, chartConfig),
+				BindingsRetrievable: isBindable(release.Chart.Annotations, chartConfig),
+				PlanUpdatable:       func() *bool { b := chartConfig.planUpdatable(); return &b }(),
+				Metadata: map[string]interface{}{
+					"name":          release.Chart.Name,
+					"home":          release.Chart.Home,
+					"sources":       release.Chart.Sources,
+					"version":       release.Chart.Version,
+					"description":   release.Chart.Description,
+					"keywords":      release.Chart.Keywords,
+					"maintainers":   release.Chart.Maintainers,
+					"engine":        release.Chart.Engine,
+					"icon":          release.Chart.Icon,
+					"apiVersion":    release.Chart.ApiVersion,
+					"condition":     release.Chart.Condition,
+					"tags":          release.Chart.Tags,
+					"appVersion":    release.Chart.AppVersion,
+					"deprecated":    release.Chart.Deprecated,
+					"tillerVersion": release.Chart.TillerVersion,
+					"annotations":   release.Chart.Annotations,
+					"kubeVersion":   release.Chart.KubeVersion,
+					"urls":          release.Chart.URLs,
+					"created":       release.Chart.Created,
+					"removed":       release.Chart.Removed,
+					"digest":        release.Chart.Digest,
+					"legacyID":      legacyID,
+				},
+			},
+			InstancesRetrievable: true,
+			Plans:                getPlans(serviceID, serviceName, release.Chart.Version, chartConfig),
+		}
+		response.Services = append(response.Services, service)
+	}
+
+	glog.Infof("catalog response: %#+v.", response)
+
+	return response, nil
+}
+
+// getPlans returns the plans of a service from the chart config. The version
+// of the chart is the maintenance version of plans without a chart version.
+func getPlans(serviceID string, serviceName string, version string, chartConfig ChartConfig) []rest.Plan {
+	if len(chartConfig.Plans) == 0 {
+		return []rest.Plan{
+			{
+				Plan: osb.Plan{
 					ID:          serviceID,
 					Name:        serviceName,
 					Description: fmt.Sprintf("A default plan for %s", serviceName),
 					Free:        func() *bool { b := true; return &b }(),
 				},
-			},
-			Metadata: map[string]interface{}{
-				"name":          release.Chart.Name,
-				"home":          release.Chart.Home,
-				"sources":       release.Chart.Sources,
-				"version":       release.Chart.Version,
-				"description":   release.Chart.Description,
-				"keywords":      release.Chart.Keywords,
-				"maintainers":   release.Chart.Maintainers,
-				"engine":        release.Chart.Engine,
-				"icon":          release.Chart.Icon,
-				"apiVersion":    release.Chart.ApiVersion,
-				"condition":     release.Chart.Condition,
-				"tags":          release.Chart.Tags,
-				"appVersion":    release.Chart.AppVersion,
-				"deprecated":    release.Chart.Deprecated,
-				"tillerVersion": release.Chart.TillerVersion,
-				"annotations":   release.Chart.Annotations,
-				"kubeVersion":   release.Chart.KubeVersion,
-				"urls":          release.Chart.URLs,
-				"created":       release.Chart.Created,
-				"removed":       release.Chart.Removed,
-				"digest":        release.Chart.Digest,
+				MaintenanceInfo: &rest.MaintenanceInfo{Version: version},
 			},
 		}
-		services[i] = service
 	}
-	osbResponse := &osb.CatalogResponse{
-		Services: services,
-	}
-
-	glog.Infof("catalog response: %#+v.", osbResponse)
-	response.CatalogResponse = *osbResponse
 
-	return response, nil
+	plans := make([]rest.Plan, len(chartConfig.Plans))
+	for i, plan := range chartConfig.Plans {
+		description := plan.Description
+		if description == "" {
+			description = fmt.Sprintf("The %s plan for %s", plan.Name, serviceName)
+		}
+		planVersion := version
+		if plan.ChartVersion != "" {
+			planVersion = plan.ChartVersion
+		}
+		plans[i] = rest.Plan{
+			Plan: osb.Plan{
+				ID:          getPlanID(serviceID, plan.Name),
+				Name:        plan.Name,
+				Description: description,
+				Free:        func() *bool { b := true; return &b }(),
+			},
+			MaintenanceInfo: &rest.MaintenanceInfo{Version: planVersion},
+		}
+	}
+	return plans
 }
 
 // Provision encapsulates the business logic for a provision operation and returns a osb.ProvisionResponse or an error.
 func (b *HelmBroker) Provision(request *osb.ProvisionRequest, c *broker.RequestContext) (*broker.ProvisionResponse, error) {
-	// Get service class for provision request.
-	class, err := b.svcatClient.ServicecatalogV1beta1().ClusterServiceClasses().Get(request.ServiceID, metav1.GetOptions{})
-	if err != nil {
-		return nil, err
-	}
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
 
-	chart, err := getChartName(class.Spec.ExternalName)
+	// Get chart for provision request.
+	chart, err := b.getChart(request.ServiceID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get chart name for service %s: %v", request.ServiceID, err)
+		return nil, err
 	}
 
 	namespace, ok := request.Context["namespace"].(string)
@@ -160,22 +322,11 @@ func (b *HelmBroker) Provision(request *osb.ProvisionRequest, c *broker.RequestC
 	}
 
 	// Get instance for provision request.
-	instanceList, err := b.svcatClient.ServicecatalogV1beta1().ServiceInstances(namespace).List(metav1.ListOptions{})
+	name, err := b.getInstanceName(namespace, request.InstanceID)
 	if err != nil {
 		return nil, err
 	}
 
-	var name string
-	for _, instance := range instanceList.Items {
-		if instance.Spec.ExternalID == request.InstanceID {
-			name = instance.Name
-			break
-		}
-	}
-	if name == "" {
-		return nil, fmt.Errorf("failed to get name for instance %s", request.InstanceID)
-	}
-
 	response := broker.ProvisionResponse{
 		ProvisionResponse: osb.ProvisionResponse{
 			DashboardURL: func() *string { s := ""; return &s }(),
@@ -186,37 +337,67 @@ func (b *HelmBroker) Provision(request *osb.ProvisionRequest, c *broker.RequestC
 		response.Async = b.async
 	}
 
-	// Install helm release.
-	resp, err := b.helmClient.InstallRelease(chart, namespace, name, request.Parameters)
+	// Record the target cluster of the instance before its release is
+	// installed, so that later requests go to the same cluster.
+	chartConfig := b.config.chart(chart)
+	releaseConfig := chartConfig.release(request.ServiceID, request.PlanID)
+	target, parameters, err := b.selectCluster(request, releaseConfig)
 	if err != nil {
 		return nil, err
 	}
+	if err := b.store.PutPlacement(&store.Placement{InstanceID: request.InstanceID, Cluster: target.name}); err != nil {
+		return nil, err
+	}
+	ref := &releaseRef{cluster: target, namespace: namespace, name: name}
+
+	// Install helm release with the plan values overridden by the parameters.
+	values := parameters
+	if plan := chartConfig.plan(request.ServiceID, request.PlanID); plan != nil {
+		values = mergeValues(plan.Values, parameters)
+	}
+	opts := releaseConfig.installOptions()
+	if plan := chartConfig.plan(request.ServiceID, request.PlanID); plan != nil {
+		opts.ChartVersion = plan.ChartVersion
+	}
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		b.deleteFailedPlacement(ctx, ref, request.InstanceID)
+		return nil, err
+	}
+	resp, err := helmClient.InstallRelease(ctx, chart, namespace, name, values, opts)
+	if err != nil {
+		b.deleteFailedPlacement(ctx, ref, request.InstanceID)
+		return nil, err
+	}
 
 	release := resp.GetRelease()
+	dashboardURL, err := b.getDashboardURL(ctx, ref)
+	if err != nil {
+		glog.Warningf("failed to get dashboard URL of release %s: %v", release.Name, err)
+	}
+	response.DashboardURL = &dashboardURL
+
 	glog.Infof("provision response: %#+v.", response)
-	glog.Infof("release %s installed from chart %s.", release.Name, release.Chart.Metadata.Name)
+	if commit := release.Chart.Metadata.Annotations[helm.GitCommitAnnotation]; commit != "" {
+		glog.Infof("release %s installed from chart %s of commit %s.", release.Name, release.Chart.Metadata.Name, commit)
+	} else {
+		glog.Infof("release %s installed from chart %s.", release.Name, release.Chart.Metadata.Name)
+	}
 
 	return &response, nil
 }
 
 // Deprovision encapsulates the business logic for a deprovision operation and returns a osb.DeprovisionResponse or an error.
 func (b *HelmBroker) Deprovision(request *osb.DeprovisionRequest, c *broker.RequestContext) (*broker.DeprovisionResponse, error) {
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
+
 	// Get instance for provision request.
-	instanceList, err := b.svcatClient.ServicecatalogV1beta1().ServiceInstances("").List(metav1.ListOptions{})
+	ref, err := b.getRelease("", request.InstanceID)
 	if err != nil {
 		return nil, err
 	}
-
-	var name string
-	for _, instance := range instanceList.Items {
-		if instance.Spec.ExternalID == request.InstanceID {
-			name = instance.Name
-			break
-		}
-	}
-	if name == "" {
-		return nil, fmt.Errorf("failed to get name for instance %s", request.InstanceID)
-	}
+	name := ref.name
 
 	response := broker.DeprovisionResponse{
 		DeprovisionResponse: osb.DeprovisionResponse{
@@ -227,14 +408,28 @@ func (b *HelmBroker) Deprovision(request *osb.DeprovisionRequest, c *broker.Requ
 		response.Async = b.async
 	}
 
-	resp, err := b.helmClient.DeleteRelease(name)
+	opts := ReleaseConfig{}.deleteOptions()
+	if chart, err := b.getChart(request.ServiceID); err != nil {
+		glog.Warningf("deleting release %s with default options: %v", name, err)
+	} else {
+		opts = b.config.chart(chart).release(request.ServiceID, request.PlanID).deleteOptions()
+	}
+
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := helmClient.DeleteRelease(ctx, name, opts)
 	if err != nil {
 		if isReleaseNotFoundError(name, err) {
+			b.deleteInstanceRecords(request.InstanceID)
 			return &response, nil
 		}
 		return nil, err
 	}
 
+	b.deleteInstanceRecords(request.InstanceID)
+
 	release := resp.GetRelease()
 	glog.Infof("deprovision response: %#+v.", response)
 	glog.Infof("release %s from chart %s uninstalled", release.Name, release.Chart.Metadata.Name)
@@ -242,75 +437,345 @@ func (b *HelmBroker) Deprovision(request *osb.DeprovisionRequest, c *broker.Requ
 	return &response, nil
 }
 
+// deleteFailedPlacement deletes the placement of an instance whose release
+// failed to install. The placement is kept if the cluster holds a failed
+// release of the instance, so that deprovisioning deletes it.
+func (b *HelmBroker) deleteFailedPlacement(ctx context.Context, ref *releaseRef, instanceID string) {
+	if helmClient, err := ref.helmClient(); err == nil {
+		if _, err := helmClient.ReleaseStatus(ctx, ref.name); err == nil || !isReleaseNotFoundError(ref.name, err) {
+			return
+		}
+	}
+	if err := b.store.DeletePlacement(instanceID); err != nil {
+		glog.Warningf("failed to delete placement of instance %s: %v", instanceID, err)
+	}
+}
+
+// deleteInstanceRecords deletes the records of a deprovisioned instance.
+func (b *HelmBroker) deleteInstanceRecords(instanceID string) {
+	if err := b.store.DeleteInstance(instanceID); err != nil {
+		glog.Warningf("failed to delete record of instance %s: %v", instanceID, err)
+	}
+	if err := b.store.DeletePlacement(instanceID); err != nil {
+		glog.Warningf("failed to delete placement of instance %s: %v", instanceID, err)
+	}
+}
+
 // LastOperation encapsulates the business logic for a last operation request and returns a osb.LastOperationResponse or an error.
 func (b *HelmBroker) LastOperation(request *osb.LastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
-	resp, err := b.helmClient.ReleaseStatus(request.InstanceID)
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
+
+	// Get instance for last operation request.
+	ref, err := b.getRelease("", request.InstanceID)
 	if err != nil {
 		return nil, err
 	}
+	name := ref.name
+
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := helmClient.ReleaseStatus(ctx, name)
+	if err != nil {
+		if isReleaseNotFoundError(name, err) {
+			return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusGone}
+		}
+		return nil, err
+	}
 
 	state := getReleaseStatusCode(resp)
+	description := resp.GetInfo().GetDescription()
+	if resp.GetInfo().GetStatus().GetCode() == release.Status_DEPLOYED {
+		// A release rolled back after a failed update is deployed, but the
+		// update has failed.
+		instance, err := b.getRolledBackOperation(ctx, request.InstanceID, ref)
+		if err != nil {
+			return nil, err
+		}
+		if instance != nil {
+			return &broker.LastOperationResponse{
+				LastOperationResponse: osb.LastOperationResponse{
+					State:       instance.State,
+					Description: &instance.Description,
+				},
+			}, nil
+		}
+
+		// Tiller reports a release as deployed once its resources are
+		// created, so wait until the resources are ready as well.
+		readiness, rel, err := b.getReleaseReadiness(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !readiness.Ready {
+			state = osb.StateInProgress
+		}
+		description = describeRegistryRewrites(readiness.Description, rel)
+	}
+
 	response := broker.LastOperationResponse{
 		LastOperationResponse: osb.LastOperationResponse{
 			State: state,
 		},
 	}
+	if description != "" {
+		response.Description = &description
+	}
 
 	return &response, nil
 }
 
+// getReleaseReadiness returns the readiness of the resources of a release, and
+// the latest revision of the release.
+func (b *HelmBroker) getReleaseReadiness(ctx context.Context, ref *releaseRef) (*kube.Readiness, *release.Release, error) {
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := helmClient.ReleaseContent(ctx, ref.name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resources, err := kube.ParseManifest(content.GetRelease().GetManifest())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	readiness, err := kube.GetReadiness(ref.cluster.kubeClient, ref.namespace, resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	return readiness, content.GetRelease(), nil
+}
+
+// describeRegistryRewrites appends the registry rewrites and the injected
+// image registry recorded in the chart of a release to a description.
+func describeRegistryRewrites(description string, rel *release.Release) string {
+	annotations := rel.GetChart().GetMetadata().GetAnnotations()
+	var parts []string
+	if description != "" {
+		parts = append(parts, description)
+	}
+	if rewrites := annotations[helm.RegistryRewritesAnnotation]; rewrites != "" {
+		parts = append(parts, "image registries rewritten: "+rewrites)
+	}
+	if registry := annotations[helm.ImageRegistryAnnotation]; registry != "" {
+		parts = append(parts, "global.imageRegistry set to "+registry)
+	}
+	return strings.Join(parts, ", ")
+}
+
 // Bind encapsulates the business logic for a bind operation and returns a osb.BindResponse or an error.
 func (b *HelmBroker) Bind(request *osb.BindRequest, c *broker.RequestContext) (*broker.BindResponse, error) {
-	response := broker.BindResponse{
-		BindResponse: osb.BindResponse{
-			Credentials: nil,
-		},
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
+
+	// Return the binding if it is already bound or being bound.
+	binding, err := b.store.GetBinding(request.InstanceID, request.BindingID)
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
 	}
-	if request.AcceptsIncomplete {
-		response.Async = b.async
+	if err == nil && binding.Operation == bindOperation && binding.State != osb.StateFailed {
+		if !reflect.DeepEqual(binding.Parameters, request.Parameters) {
+			return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusConflict}
+		}
+		response := broker.BindResponse{
+			BindResponse: osb.BindResponse{
+				Credentials: binding.Credentials,
+			},
+			Exists: binding.State == osb.StateSucceeded,
+		}
+		if binding.State == osb.StateInProgress {
+			response.Async = true
+			response.OperationKey = &binding.Operation
+		}
+		return &response, nil
+	}
+
+	// Get instance for bind request.
+	ref, err := b.getRelease("", request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	chart, err := b.getChart(request.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	chartConfig := b.config.chart(chart)
+	bind := func(binding *store.Binding) error {
+		credentials, err := b.getCredentials(ctx, ref, binding.BindingID, binding.Parameters, chartConfig)
+		if err != nil {
+			return fmt.Errorf("failed to get credentials for instance %s: %v", binding.InstanceID, err)
+		}
+		binding.Credentials = credentials
+		return nil
 	}
 
+	bindJob, err := b.hasBindJob(ctx, ref, chartConfig)
+	if err != nil {
+		return nil, err
+	}
+	if bindJob && !request.AcceptsIncomplete {
+		return nil, asyncRequiredError()
+	}
+
+	binding = &store.Binding{
+		InstanceID: request.InstanceID,
+		BindingID:  request.BindingID,
+		Parameters: request.Parameters,
+		Operation:  bindOperation,
+	}
+	response := broker.BindResponse{}
+	if request.AcceptsIncomplete && (b.async || bindJob) {
+		// The operation outlives the request.
+		ctx = b.ctx
+		if err := b.startBindingOperation(binding, bind); err != nil {
+			return nil, err
+		}
+		response.Async = true
+		response.OperationKey = &binding.Operation
+		return &response, nil
+	}
+
+	if err := b.runBindingOperation(binding, bind); err != nil {
+		return nil, err
+	}
+	response.Credentials = binding.Credentials
+
 	return &response, nil
 }
 
 // Unbind encapsulates the business logic for an unbind operation and returns a osb.UnbindResponse or an error.
 func (b *HelmBroker) Unbind(request *osb.UnbindRequest, c *broker.RequestContext) (*broker.UnbindResponse, error) {
-	return &broker.UnbindResponse{}, nil
-}
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
 
-// Update encapsulates the business logic for an update operation and returns a osb.UpdateInstanceResponse or an error.
-func (b *HelmBroker) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
-	// Get service class for update request.
-	class, err := b.svcatClient.ServicecatalogV1beta1().ClusterServiceClasses().Get(request.ServiceID, metav1.GetOptions{})
+	binding, err := b.store.GetBinding(request.InstanceID, request.BindingID)
+	if err == store.ErrNotFound {
+		return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusGone}
+	} else if err != nil {
+		return nil, err
+	}
+	if binding.Operation == unbindOperation && binding.State == osb.StateInProgress {
+		return &broker.UnbindResponse{
+			UnbindResponse: osb.UnbindResponse{
+				Async:        true,
+				OperationKey: &binding.Operation,
+			},
+		}, nil
+	}
+
+	// Get instance for unbind request.
+	ref, err := b.getRelease("", request.InstanceID)
 	if err != nil {
 		return nil, err
 	}
 
-	chart, err := getChartName(class.Spec.ExternalName)
+	chart, err := b.getChart(request.ServiceID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get chart name for service %s: %v", request.ServiceID, err)
+		return nil, err
 	}
 
-	namespace, ok := request.Context["namespace"].(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to get namespace for instance %s", request.InstanceID)
+	chartConfig := b.config.chart(chart)
+	unbind := func(binding *store.Binding) error {
+		if err := b.revokeCredentials(ctx, ref, binding.BindingID, chartConfig); err != nil {
+			return fmt.Errorf("failed to revoke credentials for binding %s: %v", binding.BindingID, err)
+		}
+		return nil
 	}
 
-	// Get instance for update request.
-	instanceList, err := b.svcatClient.ServicecatalogV1beta1().ServiceInstances(namespace).List(metav1.ListOptions{})
+	bindJob, err := b.hasBindJob(ctx, ref, chartConfig)
 	if err != nil {
 		return nil, err
 	}
+	if bindJob && !request.AcceptsIncomplete {
+		return nil, asyncRequiredError()
+	}
 
-	var name string
-	for _, instance := range instanceList.Items {
-		if instance.Spec.ExternalID == request.InstanceID {
-			name = instance.Name
-			break
+	binding.Operation = unbindOperation
+	response := broker.UnbindResponse{}
+	if request.AcceptsIncomplete && (b.async || bindJob) {
+		// The operation outlives the request.
+		ctx = b.ctx
+		if err := b.startBindingOperation(binding, unbind); err != nil {
+			return nil, err
 		}
+		response.Async = true
+		response.OperationKey = &binding.Operation
+		return &response, nil
 	}
-	if name == "" {
-		return nil, fmt.Errorf("failed to get name for instance %s", request.InstanceID)
+
+	if err := b.runBindingOperation(binding, unbind); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// BindingLastOperation returns the state of the last operation on a binding.
+func (b *HelmBroker) BindingLastOperation(request *osb.BindingLastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error) {
+	binding, err := b.store.GetBinding(request.InstanceID, request.BindingID)
+	if err == store.ErrNotFound {
+		// The record of a binding is deleted once it is unbound.
+		return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusGone}
+	} else if err != nil {
+		return nil, err
+	}
+
+	response := broker.LastOperationResponse{
+		LastOperationResponse: osb.LastOperationResponse{
+			State: binding.State,
+		},
+	}
+	if binding.Description != "" {
+		response.Description = &binding.Description
+	}
+
+	return &response, nil
+}
+
+// GetBinding returns the credentials and parameters of a binding.
+func (b *HelmBroker) GetBinding(request *osb.GetBindingRequest, c *broker.RequestContext) (*osb.GetBindingResponse, error) {
+	binding, err := b.store.GetBinding(request.InstanceID, request.BindingID)
+	if err == store.ErrNotFound {
+		return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusNotFound}
+	} else if err != nil {
+		return nil, err
+	}
+	if binding.Operation != bindOperation || binding.State != osb.StateSucceeded {
+		return nil, osb.HTTPStatusCodeError{StatusCode: http.StatusNotFound}
+	}
+
+	return &osb.GetBindingResponse{
+		Credentials: binding.Credentials,
+		Parameters:  binding.Parameters,
+	}, nil
+}
+
+// Update encapsulates the business logic for an update operation and returns a osb.UpdateInstanceResponse or an error.
+func (b *HelmBroker) Update(request *osb.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
+	return b.UpdateInstance(&rest.UpdateInstanceRequest{UpdateInstanceRequest: *request}, c)
+}
+
+// UpdateInstance upgrades the release of an instance to the chart version of a
+// new plan, or to the maintenance version requested by the platform.
+func (b *HelmBroker) UpdateInstance(request *rest.UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error) {
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
+
+	namespace, ok := request.Context["namespace"].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to get namespace for instance %s", request.InstanceID)
+	}
+
+	update, err := b.getReleaseUpdate(ctx, request, namespace)
+	if err != nil {
+		return nil, err
 	}
 
 	response := broker.UpdateInstanceResponse{
@@ -322,18 +787,237 @@ func (b *HelmBroker) Update(request *osb.UpdateInstanceRequest, c *broker.Reques
 		response.Async = b.async
 	}
 
-	resp, err := b.helmClient.UpdateRelease(chart, name, request.Parameters)
+	release, err := b.upgradeRelease(ctx, request.InstanceID, update)
 	if err != nil {
+		if _, ok := err.(rollbackError); ok && response.Async {
+			// The failure is reported by the last operation of the instance.
+			glog.Errorf("failed to update release %s: %v", update.release.name, err)
+			return &response, nil
+		}
 		return nil, err
 	}
 
-	release := resp.GetRelease()
 	glog.Infof("update response: %#+v.", response)
-	glog.Infof("release %s from chart %s updated", release.Name, release.Chart.Metadata.Name)
+	glog.Infof("release %s from chart %s updated to version %s", release.Name, release.Chart.Metadata.Name, release.Chart.Metadata.Version)
 
 	return &response, nil
 }
 
+// upgradeRelease upgrades the release of an instance, and rolls the release
+// back if the upgrade fails and failed upgrades are rolled back.
+func (b *HelmBroker) upgradeRelease(ctx context.Context, instanceID string, update *releaseUpdate) (*release.Release, error) {
+	if err := b.store.DeleteInstance(instanceID); err != nil {
+		return nil, err
+	}
+
+	helmClient, err := update.release.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := helmClient.UpdateRelease(ctx, update.chart, update.release.name, update.values, update.opts)
+	if err != nil {
+		if update.releaseConfig.rollbackOnFailure() {
+			err = b.rollbackRelease(ctx, instanceID, update.release, update.opts, err)
+		}
+		return nil, err
+	}
+
+	return resp.GetRelease(), nil
+}
+
+// releaseUpdate is the upgrade of the release of an instance.
+type releaseUpdate struct {
+	chart         string
+	release       *releaseRef
+	values        map[string]interface{}
+	opts          helm.ReleaseOptions
+	releaseConfig ReleaseConfig
+}
+
+// getReleaseUpdate returns the upgrade of the release of an instance for an
+// update request. The instance is searched in all namespaces if the namespace
+// is empty.
+func (b *HelmBroker) getReleaseUpdate(ctx context.Context, request *rest.UpdateInstanceRequest, namespace string) (*releaseUpdate, error) {
+	// Get chart for update request.
+	chart, err := b.getChart(request.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get instance for update request.
+	ref, err := b.getRelease(namespace, request.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var previousPlanID string
+	if request.PreviousValues != nil {
+		previousPlanID = request.PreviousValues.PlanID
+	}
+	planID := previousPlanID
+	if request.PlanID != nil {
+		planID = *request.PlanID
+	}
+
+	chartConfig := b.config.chart(chart)
+	previousPlan, plan := chartConfig.plan(request.ServiceID, previousPlanID), chartConfig.plan(request.ServiceID, planID)
+	if previousPlanID != "" && planID != previousPlanID {
+		if err := chartConfig.validatePlanUpdate(request.ServiceID, previousPlanID, planID); err != nil {
+			return nil, err
+		}
+	} else {
+		previousPlan = plan
+	}
+
+	releaseConfig := chartConfig.release(request.ServiceID, planID)
+	mode, parameters, err := getUpdateMode(request.Parameters, releaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	values, err := b.getUpdateValues(ctx, ref, mode, previousPlan, plan, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := releaseConfig.upgradeOptions()
+	if plan != nil {
+		opts.ChartVersion = plan.ChartVersion
+	}
+	if request.MaintenanceInfo != nil {
+		if err := b.validateMaintenanceInfo(chart, opts.ChartVersion, request.MaintenanceInfo); err != nil {
+			return nil, err
+		}
+		opts.ChartVersion = request.MaintenanceInfo.Version
+	}
+
+	return &releaseUpdate{
+		chart:         chart,
+		release:       ref,
+		values:        values,
+		opts:          opts,
+		releaseConfig: releaseConfig,
+	}, nil
+}
+
+// validateMaintenanceInfo returns an error if the maintenance version requested
+// by the platform is not the version advertised for the plan in the catalog.
+func (b *HelmBroker) validateMaintenanceInfo(chart string, version string, maintenanceInfo *rest.MaintenanceInfo) error {
+	if version == "" {
+		latest, err := b.clusters[""].helmClients.Default().ChartVersion(chart)
+		if err != nil {
+			return fmt.Errorf("failed to get version of chart %s: %v", chart, err)
+		}
+		version = latest
+	}
+
+	if maintenanceInfo.Version != version {
+		errorMessage := "MaintenanceInfoConflict"
+		description := fmt.Sprintf("maintenance version %s does not match the version %s of the plan", maintenanceInfo.Version, version)
+		return osb.HTTPStatusCodeError{
+			StatusCode:   http.StatusUnprocessableEntity,
+			ErrorMessage: &errorMessage,
+			Description:  &description,
+		}
+	}
+	return nil
+}
+
+// GetDashboardURL returns the dashboard URL of an instance, or an empty string
+// if the resources of the instance have no addresses yet.
+func (b *HelmBroker) GetDashboardURL(instanceID string, c *broker.RequestContext) (string, error) {
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
+
+	ref, err := b.getRelease("", instanceID)
+	if err != nil {
+		return "", err
+	}
+
+	return b.getDashboardURL(ctx, ref)
+}
+
+// getChart returns the name of the chart of the service with the service ID.
+func (b *HelmBroker) getChart(serviceID string) (string, error) {
+	class, err := b.svcatClient.ServicecatalogV1beta1().ClusterServiceClasses().Get(serviceID, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	chart, err := getChartName(class.Spec.ExternalName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get chart name for service %s: %v", serviceID, err)
+	}
+	return chart, nil
+}
+
+// getLegacyServiceIDs returns the IDs of the service classes registered while
+// service IDs were derived from chart digests, by service name. The services
+// keep these IDs, so that their classes, default plans and instances stay
+// valid. Such classes are told apart by the chart digest in their metadata, or
+// by the legacyID metadata once they are advertised with a newer chart, and
+// classes which are still in the catalog of the broker take precedence. Only
+// the classes of the broker are considered. An error is returned if they
+// cannot be listed, since advertising new IDs would replace the classes.
+func (b *HelmBroker) getLegacyServiceIDs() (map[string]string, error) {
+	selector := fields.OneTermEqualSelector("spec.clusterServiceBrokerName", b.brokerName).String()
+	classes, err := b.svcatClient.ServicecatalogV1beta1().ClusterServiceClasses().List(metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service classes of broker %s: %v", b.brokerName, err)
+	}
+
+	ids := map[string]string{}
+	removed := map[string]bool{}
+	for _, class := range classes.Items {
+		if class.Spec.ClusterServiceBrokerName != b.brokerName || class.Spec.ExternalMetadata == nil {
+			continue
+		}
+		var metadata struct {
+			Digest   string `json:"digest"`
+			LegacyID bool   `json:"legacyID"`
+		}
+		if err := json.Unmarshal(class.Spec.ExternalMetadata.Raw, &metadata); err != nil {
+			continue
+		}
+		if !metadata.LegacyID && (len(class.Spec.ExternalID) != 24 || !strings.HasPrefix(metadata.Digest, class.Spec.ExternalID)) {
+			continue
+		}
+		name := class.Spec.ExternalName
+		if _, ok := ids[name]; ok && !removed[name] {
+			continue
+		}
+		ids[name] = class.Spec.ExternalID
+		removed[name] = class.Status.RemovedFromBrokerCatalog
+	}
+	return ids, nil
+}
+
+// getInstanceName returns the name of the service instance with the instance
+// ID, which is also the name of its release. All namespaces are searched if the
+// namespace is empty.
+func (b *HelmBroker) getInstanceName(namespace string, instanceID string) (string, error) {
+	instance, err := b.getInstance(namespace, instanceID)
+	if err != nil {
+		return "", err
+	}
+	return instance.Name, nil
+}
+
+// getInstance returns the service instance with the instance ID. All
+// namespaces are searched if the namespace is empty.
+func (b *HelmBroker) getInstance(namespace string, instanceID string) (*v1beta1.ServiceInstance, error) {
+	instanceList, err := b.svcatClient.ServicecatalogV1beta1().ServiceInstances(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, instance := range instanceList.Items {
+		if instance.Spec.ExternalID == instanceID {
+			return &instanceList.Items[i], nil
+		}
+	}
+	return nil, instanceNotFoundError(instanceID)
+}
+
 // ValidateBrokerAPIVersion encapsulates the business logic of validating the OSB API version sent to the broker with every request and returns an error.
 func (b *HelmBroker) ValidateBrokerAPIVersion(version string) error {
 	return nil
@@ -0,0 +1,68 @@
+package broker
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsCollector collects the metrics of the broker.
+type MetricsCollector struct {
+	// FleetUpgradeInstances is the number of instances of fleet upgrades by
+	// service and state.
+	FleetUpgradeInstances *prom.GaugeVec
+	// FleetUpgrades counts the instance upgrades of fleet upgrades by service
+	// and result.
+	FleetUpgrades *prom.CounterVec
+	// HelmRetries counts the retries of calls to Helm by operation.
+	HelmRetries *prom.CounterVec
+	// HelmRetriedCalls counts the calls to Helm which were retried by
+	// operation and result.
+	HelmRetriedCalls *prom.CounterVec
+}
+
+// NewMetricsCollector constructs a metrics collector of the broker.
+func NewMetricsCollector() *MetricsCollector {
+	return &MetricsCollector{
+		FleetUpgradeInstances: prom.NewGaugeVec(prom.GaugeOpts{
+			Name: "helm_broker_fleet_upgrade_instances",
+			Help: "Number of instances of fleet upgrades by state.",
+		}, []string{"service", "state"}),
+		FleetUpgrades: prom.NewCounterVec(prom.CounterOpts{
+			Name: "helm_broker_fleet_upgrades_total",
+			Help: "Total amount of instance upgrades of fleet upgrades by result.",
+		}, []string{"service", "result"}),
+		HelmRetries: prom.NewCounterVec(prom.CounterOpts{
+			Name: "helm_broker_helm_retries_total",
+			Help: "Total amount of retries of calls to Helm by operation.",
+		}, []string{"operation"}),
+		HelmRetriedCalls: prom.NewCounterVec(prom.CounterOpts{
+			Name: "helm_broker_helm_retried_calls_total",
+			Help: "Total amount of calls to Helm which were retried by result.",
+		}, []string{"operation", "result"}),
+	}
+}
+
+// observeRetries records the retries of a call to Helm.
+func (c *MetricsCollector) observeRetries(operation string, retries int, err error) {
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	c.HelmRetries.WithLabelValues(operation).Add(float64(retries))
+	c.HelmRetriedCalls.WithLabelValues(operation, result).Inc()
+}
+
+// Describe returns all descriptions of the collector.
+func (c *MetricsCollector) Describe(ch chan<- *prom.Desc) {
+	c.FleetUpgradeInstances.Describe(ch)
+	c.FleetUpgrades.Describe(ch)
+	c.HelmRetries.Describe(ch)
+	c.HelmRetriedCalls.Describe(ch)
+}
+
+// Collect returns the current state of all metrics of the collector.
+func (c *MetricsCollector) Collect(ch chan<- prom.Metric) {
+	c.FleetUpgradeInstances.Collect(ch)
+	c.FleetUpgrades.Collect(ch)
+	c.HelmRetries.Collect(ch)
+	c.HelmRetriedCalls.Collect(ch)
+}
@@ -0,0 +1,47 @@
+package broker
+
+import (
+	"github.com/golang/glog"
+	"github.com/huangjiuyuan/helm-broker/pkg/store"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+// Operation keys of asynchronous binding operations.
+const (
+	bindOperation   osb.OperationKey = "bind"
+	unbindOperation osb.OperationKey = "unbind"
+)
+
+// runBindingOperation runs an operation on a binding and records its result in
+// the store. The record of a binding is deleted once it is unbound.
+func (b *HelmBroker) runBindingOperation(binding *store.Binding, operation func(*store.Binding) error) error {
+	if err := operation(binding); err != nil {
+		glog.Errorf("%s operation for binding %s failed: %v", binding.Operation, binding.BindingID, err)
+		binding.State = osb.StateFailed
+		binding.Description = err.Error()
+		if err := b.store.PutBinding(binding); err != nil {
+			glog.Errorf("failed to record %s operation for binding %s: %v", binding.Operation, binding.BindingID, err)
+		}
+		return err
+	}
+
+	if binding.Operation == unbindOperation {
+		return b.store.DeleteBinding(binding.InstanceID, binding.BindingID)
+	}
+	binding.State = osb.StateSucceeded
+	binding.Description = ""
+	return b.store.PutBinding(binding)
+}
+
+// startBindingOperation records an operation on a binding as in progress and
+// runs it in the background.
+func (b *HelmBroker) startBindingOperation(binding *store.Binding, operation func(*store.Binding) error) error {
+	binding.State = osb.StateInProgress
+	binding.Description = ""
+	if err := b.store.PutBinding(binding); err != nil {
+		return err
+	}
+
+	go b.runBindingOperation(binding, operation)
+	return nil
+}
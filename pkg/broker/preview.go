@@ -0,0 +1,50 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/huangjiuyuan/helm-broker/pkg/kube"
+	"github.com/huangjiuyuan/helm-broker/pkg/rest"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// PreviewUpdate renders the release of an instance with a dry-run upgrade for
+// an update request, and returns the diff of the rendered manifest against the
+// manifest of the current revision of the release. The values of Secrets are
+// left out of the diff.
+func (b *HelmBroker) PreviewUpdate(request *rest.UpdateInstanceRequest, c *broker.RequestContext) (*rest.UpdatePreview, error) {
+	ctx, cancel := b.requestContext(c)
+	defer cancel()
+
+	namespace, _ := request.Context["namespace"].(string)
+	update, err := b.getReleaseUpdate(ctx, request, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	helmClient, err := update.release.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	content, err := helmClient.ReleaseContent(ctx, update.release.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release %s: %v", update.release.name, err)
+	}
+
+	update.opts.DryRun = true
+	resp, err := helmClient.UpdateRelease(ctx, update.chart, update.release.name, update.values, update.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := kube.DiffManifests(content.GetRelease().GetManifest(), resp.GetRelease().GetManifest())
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff manifests of release %s: %v", update.release.name, err)
+	}
+
+	return &rest.UpdatePreview{
+		Revision:     content.GetRelease().GetVersion(),
+		ChartVersion: resp.GetRelease().GetChart().GetMetadata().GetVersion(),
+		Diff:         diff,
+	}, nil
+}
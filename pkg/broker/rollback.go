@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	"github.com/huangjiuyuan/helm-broker/pkg/store"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// updateOperation is the operation key of instance updates.
+const updateOperation osb.OperationKey = "update"
+
+// rollbackError is returned by rollbackRelease if a release was rolled back,
+// which is recorded as the last operation of its instance.
+type rollbackError struct {
+	description string
+}
+
+func (e rollbackError) Error() string {
+	return e.description
+}
+
+// rollbackRelease rolls a release back to its last deployed revision after a
+// failed upgrade, and records the failure of the update so that it is reported
+// by the last operation of the instance. The returned error is a rollbackError if
+// the release was rolled back.
+func (b *HelmBroker) rollbackRelease(ctx context.Context, instanceID string, ref *releaseRef, opts helm.ReleaseOptions, upgradeErr error) error {
+	name := ref.name
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return fmt.Errorf("%v, failed to get release %s for rollback: %v", upgradeErr, name, err)
+	}
+	content, err := helmClient.ReleaseContent(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%v, failed to get release %s for rollback: %v", upgradeErr, name, err)
+	}
+	if content.GetRelease().GetInfo().GetStatus().GetCode() == release.Status_DEPLOYED {
+		// The upgrade failed before a new revision was created.
+		return upgradeErr
+	}
+
+	revision, err := helmClient.LastDeployedRevision(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%v, failed to roll back release %s: %v", upgradeErr, name, err)
+	}
+
+	glog.Warningf("rolling back release %s to revision %d after failed upgrade: %v", name, revision, upgradeErr)
+	resp, err := helmClient.RollbackRelease(ctx, name, revision, opts)
+	if err != nil {
+		return fmt.Errorf("%v, failed to roll back release %s to revision %d: %v", upgradeErr, name, revision, err)
+	}
+
+	description := fmt.Sprintf("%v, rolled back to revision %d", upgradeErr, revision)
+	instance := &store.Instance{
+		InstanceID:  instanceID,
+		Revision:    resp.GetRelease().GetVersion(),
+		Operation:   updateOperation,
+		State:       osb.StateFailed,
+		Description: description,
+	}
+	if err := b.store.PutInstance(instance); err != nil {
+		glog.Errorf("failed to record rollback of release %s: %v", name, err)
+		return fmt.Errorf("%s", description)
+	}
+
+	return rollbackError{description: description}
+}
+
+// getRolledBackOperation returns the last operation of an instance if its
+// release was rolled back after a failed update and has not changed since, or
+// nil otherwise.
+func (b *HelmBroker) getRolledBackOperation(ctx context.Context, instanceID string, ref *releaseRef) (*store.Instance, error) {
+	instance, err := b.store.GetInstance(instanceID)
+	if err == store.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	content, err := helmClient.ReleaseContent(ctx, ref.name)
+	if err != nil {
+		return nil, err
+	}
+	if content.GetRelease().GetVersion() != instance.Revision {
+		return nil, nil
+	}
+	return instance, nil
+}
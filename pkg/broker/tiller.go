@@ -0,0 +1,75 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm/local"
+	"github.com/huangjiuyuan/helm-broker/pkg/kube"
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+// Prefixes of the keys of Tillers in the pool of clients for helm.
+const (
+	tillerHostKey      = "host:"
+	tillerNamespaceKey = "namespace:"
+)
+
+// newHelmClients creates the pool of clients for helm of the backend. With the
+// Tiller backend, the releases of a namespace are managed by the Tiller
+// configured for the namespace, or by the Tiller discovered in the namespace
+// if Tiller runs per namespace, and by the default Tiller otherwise. All
+// clients are configured with configure.
+func newHelmClients(o Options, tillers map[string]TillerConfig, kubeClient kubeclientset.Interface, configure func(*helm.Client)) (*helm.Pool, error) {
+	switch o.Backend {
+	case "tiller", "":
+	case "local":
+		client := helm.NewClientWithBackend(local.NewBackend(kubeClient, o.Namespace), o.HelmHome)
+		configure(client)
+		return helm.NewPool(client, nil, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", o.Backend)
+	}
+
+	var tlsOptions *helm.TLSOptions
+	if o.TillerTLS.Enabled() {
+		tlsOptions = &o.TillerTLS
+	}
+	newClient := func(host string, namespace string) *helm.Client {
+		locate := func() (string, error) { return host, nil }
+		if host == "" {
+			locate = func() (string, error) { return kube.FindTiller(kubeClient, namespace) }
+		}
+		client := helm.NewClientWithBackend(helm.NewLocatedTillerBackend(locate, tlsOptions), o.HelmHome)
+		configure(client)
+		return client
+	}
+
+	route := func(namespace string) string {
+		if tiller, ok := tillers[namespace]; ok {
+			if tiller.Host != "" {
+				return tillerHostKey + tiller.Host
+			}
+			if tiller.Namespace != "" {
+				return tillerNamespaceKey + tiller.Namespace
+			}
+			return tillerNamespaceKey + namespace
+		}
+		if o.TillerPerNamespace && namespace != "" {
+			return tillerNamespaceKey + namespace
+		}
+		return ""
+	}
+	create := func(key string) (*helm.Client, error) {
+		switch {
+		case strings.HasPrefix(key, tillerHostKey):
+			return newClient(strings.TrimPrefix(key, tillerHostKey), ""), nil
+		case strings.HasPrefix(key, tillerNamespaceKey):
+			return newClient("", strings.TrimPrefix(key, tillerNamespaceKey)), nil
+		}
+		return nil, fmt.Errorf("unknown Tiller %q", key)
+	}
+
+	return helm.NewPool(newClient(o.TillerHost, o.TillerNamespace), route, create), nil
+}
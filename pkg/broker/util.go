@@ -1,7 +1,10 @@
 package broker
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 
 	osb "github.com/pmorie/go-open-service-broker-client/v2"
@@ -25,14 +28,36 @@ func getReleaseStatusCode(status *services.GetReleaseStatusResponse) osb.LastOpe
 	}
 }
 
-// getServiceID returns the service ID from the chart digest.
-func getServiceID(id string) (string, error) {
-	if len(id) >= 24 {
-		id = id[:24]
-	} else {
-		return "", fmt.Errorf("invalid id pattern")
+// getServiceID returns the service ID from the chart name. The service ID does
+// not depend on the chart version, so that instances can be upgraded to new
+// versions of the chart. Services registered with the ID derived from the chart
+// digest keep it, see getLegacyServiceIDs.
+func getServiceID(name string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:24]
+}
+
+// getPlanID returns the plan ID of a named plan of the service.
+func getPlanID(serviceID string, name string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(serviceID+"/"+name)))[:24]
+}
+
+// mergeValues returns the values of src merged into dst. Values in src take
+// precedence over values in dst.
+func mergeValues(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := merged[k].(map[string]interface{}); ok {
+				merged[k] = mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		merged[k] = v
 	}
-	return id, nil
+	return merged
 }
 
 // getServiceName returns the service name from the chart name.
@@ -60,3 +85,21 @@ func getChartName(name string) (string, error) {
 func isReleaseNotFoundError(release string, err error) bool {
 	return fmt.Sprintf("release: %q not found", release) == err.Error()
 }
+
+// serviceAccountNamespaceFile is the file holding the namespace of the
+// service account of pods.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// getBrokerNamespace returns the namespace the broker runs in, which is read
+// from the POD_NAMESPACE environment variable set by the downward API or from
+// the namespace of the service account of the broker pod.
+func getBrokerNamespace() (string, error) {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace, nil
+	}
+	data, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the namespace of the broker, the namespace flag is required outside of a cluster: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+const (
+	// reuseValues merges the parameters of an update into the values of the
+	// current revision of the release.
+	reuseValues = "reuse"
+	// resetValues resets the values of the release to the plan values
+	// overridden by the parameters of an update.
+	resetValues = "reset"
+	// updateValuesParameter is the parameter choosing the update semantics of
+	// a single update request. It is not passed on to the chart.
+	updateValuesParameter = "helm-broker.io/update-values"
+)
+
+// updateValues returns the update semantics of the release config, which
+// defaults to reusing the values.
+func (r ReleaseConfig) updateValues() string {
+	if r.UpdateValues == "" {
+		return reuseValues
+	}
+	return r.UpdateValues
+}
+
+// getUpdateMode returns the update semantics chosen by the parameters of an
+// update request, or the default of the release config, and the parameters
+// without the update values parameter.
+func getUpdateMode(parameters map[string]interface{}, releaseConfig ReleaseConfig) (string, map[string]interface{}, error) {
+	mode := releaseConfig.updateValues()
+	value, ok := parameters[updateValuesParameter]
+	if !ok {
+		return mode, parameters, nil
+	}
+
+	mode, _ = value.(string)
+	if mode != reuseValues && mode != resetValues {
+		description := fmt.Sprintf("parameter %s must be %q or %q", updateValuesParameter, reuseValues, resetValues)
+		return "", nil, osb.HTTPStatusCodeError{
+			StatusCode:  http.StatusBadRequest,
+			Description: &description,
+		}
+	}
+
+	stripped := make(map[string]interface{}, len(parameters))
+	for k, v := range parameters {
+		if k != updateValuesParameter {
+			stripped[k] = v
+		}
+	}
+	return mode, stripped, nil
+}
+
+// getUpdateValues returns the values a release is upgraded with. Values of the
+// previous plan which were not overridden are replaced by the values of the new
+// plan if the plan of the instance changes.
+func (b *HelmBroker) getUpdateValues(ctx context.Context, ref *releaseRef, mode string, previousPlan *PlanConfig, plan *PlanConfig, parameters map[string]interface{}) (map[string]interface{}, error) {
+	var planValues map[string]interface{}
+	if plan != nil {
+		planValues = plan.Values
+	}
+	if mode == resetValues {
+		return mergeValues(planValues, parameters), nil
+	}
+
+	helmClient, err := ref.helmClient()
+	if err != nil {
+		return nil, err
+	}
+	values, err := helmClient.ReleaseValues(ctx, ref.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get values of release %s: %v", ref.name, err)
+	}
+	if previousPlan != plan {
+		if previousPlan != nil {
+			values = removeValues(values, previousPlan.Values)
+		}
+		values = mergeValues(values, planValues)
+	}
+	return mergeValues(values, parameters), nil
+}
+
+// removeValues returns the values without the values which are equal to the
+// defaults.
+func removeValues(values map[string]interface{}, defaults map[string]interface{}) map[string]interface{} {
+	removed := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		d, ok := defaults[k]
+		if !ok {
+			removed[k] = v
+			continue
+		}
+		if vMap, ok := v.(map[string]interface{}); ok {
+			if dMap, ok := d.(map[string]interface{}); ok {
+				if vMap = removeValues(vMap, dMap); len(vMap) > 0 {
+					removed[k] = vMap
+				}
+				continue
+			}
+		}
+		if !reflect.DeepEqual(v, d) {
+			removed[k] = v
+		}
+	}
+	return removed
+}
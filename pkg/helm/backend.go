@@ -0,0 +1,28 @@
+package helm
+
+import (
+	"context"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// Backend installs charts as releases and keeps the revisions of the releases.
+// Calls are cancelled when their context is done.
+type Backend interface {
+	// InstallRelease installs a chart as a new release.
+	InstallRelease(ctx context.Context, ch *chart.Chart, namespace string, name string, rawValues []byte, opts ReleaseOptions) (*services.InstallReleaseResponse, error)
+	// UpdateRelease upgrades a release to a chart. The values replace the
+	// values of the current revision of the release.
+	UpdateRelease(ctx context.Context, ch *chart.Chart, name string, rawValues []byte, opts ReleaseOptions) (*services.UpdateReleaseResponse, error)
+	// DeleteRelease uninstalls a release and purges its revisions.
+	DeleteRelease(ctx context.Context, name string, opts ReleaseOptions) (*services.UninstallReleaseResponse, error)
+	// RollbackRelease rolls a release back to a revision.
+	RollbackRelease(ctx context.Context, name string, revision int32, opts ReleaseOptions) (*services.RollbackReleaseResponse, error)
+	// ReleaseStatus returns the status of the latest revision of a release.
+	ReleaseStatus(ctx context.Context, name string) (*services.GetReleaseStatusResponse, error)
+	// ReleaseContent returns the latest revision of a release.
+	ReleaseContent(ctx context.Context, name string) (*services.GetReleaseContentResponse, error)
+	// ReleaseHistory returns up to max revisions of a release, latest first.
+	ReleaseHistory(ctx context.Context, name string, max int32) (*services.GetHistoryResponse, error)
+}
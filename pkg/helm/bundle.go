@@ -0,0 +1,368 @@
+package helm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/helm/cmd/helm/search"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// bundleIndexFile is the name of the index of a repository in a bundle.
+const bundleIndexFile = "index.yaml"
+
+// Bundle is an offline set of chart repositories, which is a directory with a
+// directory per repository holding the index of the repository and its chart
+// archives. The URLs in the indexes are the file names of the archives.
+// Charts are referenced as repository/chart, as charts of online
+// repositories are.
+type Bundle struct {
+	// dir is the directory of the bundle.
+	dir string
+	// indexes holds the indexes of the repositories, by name.
+	indexes map[string]*repo.IndexFile
+}
+
+// OpenBundle opens a bundle from a directory, or from a gzipped tarball of the
+// directory, which is extracted into the cache directory.
+func OpenBundle(path string, cacheDir string) (*Bundle, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %v", err)
+	}
+	dir := path
+	if !fi.IsDir() {
+		if dir, err = extractCachedBundle(path, cacheDir); err != nil {
+			return nil, fmt.Errorf("failed to extract bundle: %v", err)
+		}
+	}
+
+	repos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %v", err)
+	}
+	b := &Bundle{dir: dir, indexes: make(map[string]*repo.IndexFile)}
+	for _, r := range repos {
+		if !r.IsDir() {
+			continue
+		}
+		index, err := repo.LoadIndexFile(filepath.Join(dir, r.Name(), bundleIndexFile))
+		if err != nil {
+			glog.Warningf("repository %q of the bundle is corrupt or missing: %v", r.Name(), err)
+			continue
+		}
+		b.indexes[r.Name()] = index
+	}
+	if len(b.indexes) == 0 {
+		return nil, fmt.Errorf("bundle %s has no repositories", path)
+	}
+	return b, nil
+}
+
+// searchIndex returns the search index of the charts of the bundle.
+func (b *Bundle) searchIndex() *search.Index {
+	i := search.NewIndex()
+	for name, index := range b.indexes {
+		i.AddRepo(name, index, false)
+	}
+	return i
+}
+
+// load loads a chart of the bundle, and verifies it against the digest listed
+// in the index.
+func (b *Bundle) load(name, version string) (*chart.Chart, error) {
+	name = strings.TrimSpace(name)
+	version = strings.TrimSpace(version)
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("chart %q is not in the bundle", name)
+	}
+	index, ok := b.indexes[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("repository %q is not in the bundle", parts[0])
+	}
+	cv, err := index.Get(parts[1], version)
+	if err != nil {
+		return nil, err
+	}
+	if len(cv.URLs) == 0 {
+		return nil, fmt.Errorf("chart %q has no archive in the bundle", name)
+	}
+
+	path := filepath.Join(b.dir, parts[0], filepath.Base(cv.URLs[0]))
+	if digest := strings.TrimPrefix(cv.Digest, "sha256:"); digest != "" {
+		actual, err := digestFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %q: %v", name, err)
+		}
+		if actual != digest {
+			return nil, fmt.Errorf("digest of %q is %s, but the bundle index lists %s", name, actual, digest)
+		}
+	}
+	ch, err := chartutil.LoadFile(path)
+	if err != nil {
+		return nil, prettyError(err)
+	}
+	return ch, nil
+}
+
+// CreateBundle downloads the charts of the repositories into a bundle at the
+// path, which is written as a gzipped tarball if the path ends with .tgz or
+// .tar.gz, and as a directory otherwise. Only the latest version of each
+// chart is bundled unless allVersions is set.
+func CreateBundle(ctx context.Context, repos []*repo.Entry, allVersions bool, path string) error {
+	tarball := strings.HasSuffix(path, ".tgz") || strings.HasSuffix(path, ".tar.gz")
+	dir := path
+	if tarball {
+		tmp, err := ioutil.TempDir("", "helm-broker-bundle-")
+		if err != nil {
+			return fmt.Errorf("failed to create bundle: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	getters := getter.All(environment.EnvSettings{})
+	for _, entry := range repos {
+		if err := bundleRepository(ctx, getters, entry, allVersions, filepath.Join(dir, entry.Name)); err != nil {
+			return fmt.Errorf("failed to bundle repository %s: %v", entry.Name, err)
+		}
+	}
+
+	if tarball {
+		if err := archiveBundle(dir, path); err != nil {
+			return fmt.Errorf("failed to archive bundle: %v", err)
+		}
+	}
+	return nil
+}
+
+// bundleRepository downloads the index and the charts of a repository into a
+// directory, and writes an index referencing the downloaded archives.
+func bundleRepository(ctx context.Context, getters getter.Providers, entry *repo.Entry, allVersions bool, dir string) error {
+	// The index is downloaded to the cache path of the entry, which is
+	// relative to the directory unless it is absolute.
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	indexPath := filepath.Join(dir, bundleIndexFile)
+	config := *entry
+	config.Cache = indexPath
+	r, err := repo.NewChartRepository(&config, getters)
+	if err != nil {
+		return err
+	}
+	if err := r.DownloadIndexFile(dir); err != nil {
+		return fmt.Errorf("failed to download index: %v", err)
+	}
+	index, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return err
+	}
+	index.SortEntries()
+
+	bundled := repo.NewIndexFile()
+	baseURL := strings.TrimSuffix(entry.URL, "/") + "/"
+	for name, versions := range index.Entries {
+		if !allVersions && len(versions) > 1 {
+			versions = versions[:1]
+		}
+		for _, cv := range versions {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if len(cv.URLs) == 0 {
+				continue
+			}
+			chartURL, err := repo.ResolveReferenceURL(baseURL, cv.URLs[0])
+			if err != nil {
+				return err
+			}
+			data, err := r.Client.Get(chartURL)
+			if err != nil {
+				return fmt.Errorf("failed to download %s-%s: %v", name, cv.Version, err)
+			}
+			filename := fmt.Sprintf("%s-%s.tgz", name, cv.Version)
+			if err := ioutil.WriteFile(filepath.Join(dir, filename), data.Bytes(), 0644); err != nil {
+				return err
+			}
+
+			digest, err := digestFile(filepath.Join(dir, filename))
+			if err != nil {
+				return err
+			}
+			if expected := strings.TrimPrefix(cv.Digest, "sha256:"); expected != "" && expected != digest {
+				return fmt.Errorf("digest of %s-%s is %s, but the repository index lists %s", name, cv.Version, digest, expected)
+			}
+			bundled.Add(cv.Metadata, filename, "", digest)
+			glog.V(4).Infof("bundled chart %s/%s-%s", entry.Name, name, cv.Version)
+		}
+	}
+	bundled.SortEntries()
+	return bundled.WriteFile(indexPath, 0644)
+}
+
+// archiveBundle writes the files of a bundle directory into a gzipped tarball.
+func archiveBundle(dir, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || file == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// extractCachedBundle extracts a bundle tarball into a directory of the cache
+// directory named by the digest of the tarball, and returns the directory. The
+// directory is reused if the tarball was extracted before, and the extractions
+// of other tarballs are removed.
+func extractCachedBundle(path, cacheDir string) (string, error) {
+	digest, err := digestFile(path)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, digest)
+	if _, err := os.Stat(dir); err == nil {
+		glog.V(4).Infof("reusing bundle %s extracted into %s", path, dir)
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(cacheDir, ".extract-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractBundle(path, tmp); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+
+	files, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		glog.Warningf("failed to read bundle cache: %v", err)
+		return dir, nil
+	}
+	for _, file := range files {
+		if file.Name() != digest && digestPattern.MatchString(file.Name()) {
+			if err := os.RemoveAll(filepath.Join(cacheDir, file.Name())); err != nil {
+				glog.Warningf("failed to remove extracted bundle %s: %v", file.Name(), err)
+			}
+		}
+	}
+	return dir, nil
+}
+
+// extractBundle extracts a gzipped tarball of a bundle into a directory.
+func extractBundle(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return extractTar(gz, dir)
+}
+
+// extractTar extracts the directories and regular files of a tarball into a
+// directory.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("illegal file path %q", header.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
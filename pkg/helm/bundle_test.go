@@ -0,0 +1,180 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestCreateBundleRelativePath(t *testing.T) {
+	r := newTestRepository(t, 0)
+	defer r.close()
+	r.addChart("redis", "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(r.dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	entry := &repo.Entry{Name: "stable", URL: r.server.URL}
+	if err := CreateBundle(context.Background(), []*repo.Entry{entry}, false, "bundle"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entry.Cache != "" {
+		t.Errorf("expected repository entry not to be modified, got cache %s", entry.Cache)
+	}
+
+	index, err := repo.LoadIndexFile(filepath.Join(r.dir, "bundle", "stable", bundleIndexFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cv, err := index.Get("redis", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cv.Digest != r.digests["redis"] {
+		t.Errorf("expected digest %s, got %s", r.digests["redis"], cv.Digest)
+	}
+	if _, err := os.Stat(filepath.Join(r.dir, "bundle", "stable", cv.URLs[0])); err != nil {
+		t.Errorf("expected chart archive to be bundled: %v", err)
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	type entry struct {
+		name     string
+		typeflag byte
+		body     string
+	}
+	tests := []struct {
+		name     string
+		entries  []entry
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name: "files and directories",
+			entries: []entry{
+				{name: "stable/", typeflag: tar.TypeDir},
+				{name: "stable/index.yaml", typeflag: tar.TypeReg, body: "index"},
+				{name: "incubator/redis-1.0.0.tgz", typeflag: tar.TypeReg, body: "chart"},
+			},
+			expected: map[string]string{
+				"stable/index.yaml":         "index",
+				"incubator/redis-1.0.0.tgz": "chart",
+			},
+		},
+		{
+			name: "cleaned path",
+			entries: []entry{
+				{name: "./stable/../stable/index.yaml", typeflag: tar.TypeReg, body: "index"},
+			},
+			expected: map[string]string{
+				"stable/index.yaml": "index",
+			},
+		},
+		{
+			name: "links are skipped",
+			entries: []entry{
+				{name: "stable/link", typeflag: tar.TypeSymlink},
+				{name: "stable/index.yaml", typeflag: tar.TypeReg, body: "index"},
+			},
+			expected: map[string]string{
+				"stable/index.yaml": "index",
+			},
+		},
+		{
+			name: "parent directory",
+			entries: []entry{
+				{name: "../index.yaml", typeflag: tar.TypeReg, body: "index"},
+			},
+			err: true,
+		},
+		{
+			name: "nested parent directory",
+			entries: []entry{
+				{name: "stable/../../index.yaml", typeflag: tar.TypeReg, body: "index"},
+			},
+			err: true,
+		},
+		{
+			name: "absolute path",
+			entries: []entry{
+				{name: "/tmp/index.yaml", typeflag: tar.TypeReg, body: "index"},
+			},
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, e := range test.entries {
+				header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.body))}
+				if e.typeflag == tar.TypeSymlink {
+					header.Linkname = "/etc/passwd"
+				}
+				if err := tw.WriteHeader(header); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := tw.Write([]byte(e.body)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			root, err := ioutil.TempDir("", "extract-tar-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			dir := filepath.Join(root, "bundle")
+
+			err = extractTar(&buf, dir)
+			if test.err {
+				if err == nil {
+					t.Error("expected error")
+				}
+				if files, _ := ioutil.ReadDir(root); len(files) != 0 && files[0].Name() != "bundle" {
+					t.Errorf("file %s extracted outside of the directory", files[0].Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			actual := map[string]string{}
+			filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+				if err != nil || fi.IsDir() {
+					return err
+				}
+				rel, _ := filepath.Rel(dir, path)
+				data, _ := ioutil.ReadFile(path)
+				actual[filepath.ToSlash(rel)] = string(data)
+				return nil
+			})
+			if len(actual) != len(test.expected) {
+				t.Errorf("expected files %v, got %v", test.expected, actual)
+			}
+			for name, body := range test.expected {
+				if actual[name] != body {
+					t.Errorf("expected %s to hold %q, got %q", name, body, actual[name])
+				}
+			}
+		})
+	}
+}
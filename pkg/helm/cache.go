@@ -0,0 +1,291 @@
+package helm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// chartArchiveExt is the extension of the chart archives in the cache.
+const chartArchiveExt = ".tgz"
+
+// digestPattern matches the SHA-256 digests of chart archives in repository
+// indexes.
+var digestPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// errNotCacheable is returned by the chart cache for charts which are not
+// listed with a digest in the index of a chart repository.
+var errNotCacheable = errors.New("chart is not cacheable")
+
+// ChartCache is a cache of chart archives downloaded from chart repositories.
+// Archives are stored by the digest listed in the repository index, and are
+// verified against it when they are downloaded. Concurrent loads of the same
+// chart download it once. The least recently used archives are evicted when
+// the cache grows beyond its maximum size, and archives which have not been
+// used for the maximum age are evicted.
+type ChartCache struct {
+	// dir is the directory the archives are stored in.
+	dir string
+	// maxSize is the maximum total size of the archives in bytes, or zero
+	// if the size is not limited.
+	maxSize int64
+	// maxAge is the maximum time since an archive was last used, or zero if
+	// the age is not limited.
+	maxAge time.Duration
+
+	// mutex guards locks.
+	mutex sync.Mutex
+	// locks holds the locks of the charts which are loaded, by digest.
+	locks map[string]*chartLock
+	// evictMutex is held for reading while archives are loaded, and for
+	// writing while archives are evicted.
+	evictMutex sync.RWMutex
+}
+
+// chartLock is held while a chart is downloaded, so that it is downloaded
+// once.
+type chartLock struct {
+	sync.Mutex
+	// refs is the number of loads of the chart.
+	refs int
+}
+
+// NewChartCache creates a chart cache which stores archives in the directory.
+func NewChartCache(dir string, maxSize int64, maxAge time.Duration) *ChartCache {
+	return &ChartCache{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		locks:   make(map[string]*chartLock),
+	}
+}
+
+// load loads a chart of a chart repository from the cache, and downloads it
+// if it is not cached. errNotCacheable is returned if the chart is not listed
+// with a digest in the index of the repository.
+func (c *ChartCache) load(ctx context.Context, policy RetryPolicy, settings environment.EnvSettings, name, version string) (*chart.Chart, error) {
+	cv, err := resolveChartVersion(settings, name, version)
+	if err != nil {
+		return nil, err
+	}
+	digest := strings.TrimPrefix(cv.Digest, "sha256:")
+	if !digestPattern.MatchString(digest) {
+		return nil, errNotCacheable
+	}
+	path := filepath.Join(c.dir, digest+chartArchiveExt)
+
+	lock := c.lock(digest)
+	ch, err := c.open(path)
+	if os.IsNotExist(err) {
+		if err = c.download(ctx, policy, settings, name, cv.Version, digest, path); err == nil {
+			ch, err = c.open(path)
+		}
+	}
+	c.unlock(digest, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	c.evict()
+	return ch, nil
+}
+
+// lock acquires the lock of a chart.
+func (c *ChartCache) lock(digest string) *chartLock {
+	c.mutex.Lock()
+	lock, ok := c.locks[digest]
+	if !ok {
+		lock = &chartLock{}
+		c.locks[digest] = lock
+	}
+	lock.refs++
+	c.mutex.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+// unlock releases the lock of a chart, and removes it once no load holds it.
+func (c *ChartCache) unlock(digest string, lock *chartLock) {
+	lock.Unlock()
+
+	c.mutex.Lock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(c.locks, digest)
+	}
+	c.mutex.Unlock()
+}
+
+// open loads a cached archive and marks it as used.
+func (c *ChartCache) open(path string) (*chart.Chart, error) {
+	c.evictMutex.RLock()
+	defer c.evictMutex.RUnlock()
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return nil, err
+	}
+	ch, err := chartutil.LoadFile(path)
+	if err != nil {
+		return nil, prettyError(err)
+	}
+	return ch, nil
+}
+
+// download downloads a chart into a temporary directory, verifies its digest,
+// and moves it into the cache.
+func (c *ChartCache) download(ctx context.Context, policy RetryPolicy, settings environment.EnvSettings, name, version, digest, path string) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create chart cache: %v", err)
+	}
+	tmp, err := ioutil.TempDir(c.dir, ".download-")
+	if err != nil {
+		return fmt.Errorf("failed to create chart cache: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dl := downloader.ChartDownloader{
+		HelmHome: settings.Home,
+		Out:      os.Stdout,
+		Getters:  getter.All(settings),
+	}
+	var filename string
+	err = policy.retry(ctx, "download", func() (err error) {
+		filename, _, err = dl.DownloadTo(name, version, tmp)
+		return err
+	}, isTransientDownloadError)
+	if err != nil {
+		return fmt.Errorf("failed to download %q: %v", name, err)
+	}
+
+	actual, err := digestFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to verify %q: %v", name, err)
+	}
+	if actual != digest {
+		return fmt.Errorf("digest of %q is %s, but the repository index lists %s", name, actual, digest)
+	}
+
+	if err := os.Rename(filename, path); err != nil {
+		return fmt.Errorf("failed to cache %q: %v", name, err)
+	}
+	glog.V(4).Infof("cached chart %s-%s as %s", name, version, digest)
+	return nil
+}
+
+// evict removes the archives which have not been used for the maximum age,
+// and the least recently used archives while the cache exceeds its maximum
+// size.
+func (c *ChartCache) evict() {
+	if c.maxSize <= 0 && c.maxAge <= 0 {
+		return
+	}
+
+	c.evictMutex.Lock()
+	defer c.evictMutex.Unlock()
+
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		glog.Warningf("failed to read chart cache: %v", err)
+		return
+	}
+	var archives []os.FileInfo
+	var size int64
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != chartArchiveExt {
+			continue
+		}
+		archives = append(archives, file)
+		size += file.Size()
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].ModTime().Before(archives[j].ModTime())
+	})
+
+	expiry := time.Now().Add(-c.maxAge)
+	for _, archive := range archives {
+		expired := c.maxAge > 0 && archive.ModTime().Before(expiry)
+		if !expired && (c.maxSize <= 0 || size <= c.maxSize) {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, archive.Name())); err != nil {
+			glog.Warningf("failed to evict chart %s: %v", archive.Name(), err)
+			continue
+		}
+		glog.V(4).Infof("evicted chart %s", archive.Name())
+		size -= archive.Size()
+	}
+}
+
+// resolveChartVersion looks up the version of a chart, referenced as
+// repository/chart, in the index of the repository. errNotCacheable is
+// returned if the chart is not a chart of a repository.
+func resolveChartVersion(settings environment.EnvSettings, name, version string) (*repo.ChartVersion, error) {
+	name = strings.TrimSpace(name)
+	version = strings.TrimSpace(version)
+	if isLocalChart(settings, name) {
+		return nil, errNotCacheable
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return nil, errNotCacheable
+	}
+
+	index, err := repo.LoadIndexFile(settings.Home.CacheIndex(parts[0]))
+	if err != nil {
+		return nil, errNotCacheable
+	}
+	cv, err := index.Get(parts[1], version)
+	if err != nil {
+		return nil, errNotCacheable
+	}
+	return cv, nil
+}
+
+// isLocalChart returns true if a chart is a path, or a chart in the local
+// repository of the Helm home.
+func isLocalChart(settings environment.EnvSettings, name string) bool {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, ".") {
+		return true
+	}
+	if _, err := os.Stat(name); err == nil {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(settings.Home.Repository(), name))
+	return err == nil
+}
+
+// digestFile returns the hex-encoded SHA-256 digest of a file.
+func digestFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
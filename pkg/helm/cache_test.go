@@ -0,0 +1,250 @@
+package helm
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/helm/helmpath"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// testRepository is a chart repository served over HTTP, which counts the
+// downloads of its charts.
+type testRepository struct {
+	t        *testing.T
+	dir      string
+	settings environment.EnvSettings
+	server   *httptest.Server
+	index    *repo.IndexFile
+	digests  map[string]string
+
+	mutex     sync.Mutex
+	downloads map[string]int
+}
+
+func newTestRepository(t *testing.T, delay time.Duration) *testRepository {
+	dir, err := ioutil.TempDir("", "chart-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &testRepository{
+		t:         t,
+		dir:       dir,
+		settings:  environment.EnvSettings{Home: helmpath.Home(filepath.Join(dir, "home"))},
+		index:     repo.NewIndexFile(),
+		digests:   map[string]string{},
+		downloads: map[string]int{},
+	}
+	files := http.FileServer(http.Dir(filepath.Join(dir, "charts")))
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		r.downloads[strings.TrimPrefix(req.URL.Path, "/")]++
+		r.mutex.Unlock()
+		time.Sleep(delay)
+		files.ServeHTTP(w, req)
+	}))
+
+	for _, d := range []string{r.settings.Home.Cache(), r.settings.Home.Repository(), filepath.Join(dir, "charts")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repoFile := repo.NewRepoFile()
+	repoFile.Add(&repo.Entry{Name: "test", URL: r.server.URL, Cache: r.settings.Home.CacheIndex("test")})
+	if err := repoFile.WriteFile(r.settings.Home.RepositoryFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func (r *testRepository) close() {
+	r.server.Close()
+	os.RemoveAll(r.dir)
+}
+
+// addChart packages a chart into the repository, and lists it with the digest
+// in the index, or with its actual digest if the digest is empty.
+func (r *testRepository) addChart(name string, digest string) {
+	metadata := &chart.Metadata{Name: name, Version: "1.0.0", ApiVersion: "v1"}
+	filename, err := chartutil.Save(&chart.Chart{Metadata: metadata, Values: &chart.Config{}}, filepath.Join(r.dir, "charts"))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	actual, err := digestFile(filename)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.digests[name] = actual
+	if digest == "" {
+		digest = actual
+	}
+	r.index.Add(metadata, filepath.Base(filename), r.server.URL, "sha256:"+digest)
+	for _, path := range []string{r.settings.Home.CacheIndex("test"), filepath.Join(r.dir, "charts", "index.yaml")} {
+		if err := r.index.WriteFile(path, 0644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+}
+
+func (r *testRepository) downloadCount(name string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.downloads[name+"-1.0.0.tgz"]
+}
+
+func (r *testRepository) load(cache *ChartCache, name string) (*chart.Chart, error) {
+	return cache.load(context.Background(), RetryPolicy{}, r.settings, "test/"+name, "")
+}
+
+func (r *testRepository) cached(cache *ChartCache, name string) bool {
+	_, err := os.Stat(filepath.Join(cache.dir, r.digests[name]+chartArchiveExt))
+	return err == nil
+}
+
+func TestChartCacheLoad(t *testing.T) {
+	r := newTestRepository(t, 0)
+	defer r.close()
+	r.addChart("redis", "")
+	cache := NewChartCache(filepath.Join(r.dir, "cache"), 0, 0)
+
+	for i := 0; i < 2; i++ {
+		ch, err := r.load(cache, "redis")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ch.Metadata.Name != "redis" {
+			t.Errorf("expected chart redis, got %s", ch.Metadata.Name)
+		}
+	}
+	if count := r.downloadCount("redis"); count != 1 {
+		t.Errorf("expected chart to be downloaded once, got %d downloads", count)
+	}
+	if !r.cached(cache, "redis") {
+		t.Error("expected chart to be cached by its digest")
+	}
+
+	if _, err := cache.load(context.Background(), RetryPolicy{}, r.settings, "./redis", ""); err != errNotCacheable {
+		t.Errorf("expected local chart not to be cacheable, got %v", err)
+	}
+	if _, err := cache.load(context.Background(), RetryPolicy{}, r.settings, "unknown/redis", ""); err != errNotCacheable {
+		t.Errorf("expected chart of unknown repository not to be cacheable, got %v", err)
+	}
+}
+
+func TestChartCacheConcurrentLoads(t *testing.T) {
+	r := newTestRepository(t, 100*time.Millisecond)
+	defer r.close()
+	r.addChart("redis", "")
+	cache := NewChartCache(filepath.Join(r.dir, "cache"), 0, 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.load(cache, "redis")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if count := r.downloadCount("redis"); count != 1 {
+		t.Errorf("expected chart to be downloaded once, got %d downloads", count)
+	}
+	if len(cache.locks) != 0 {
+		t.Errorf("expected locks to be released, got %d", len(cache.locks))
+	}
+}
+
+func TestChartCacheDigestMismatch(t *testing.T) {
+	r := newTestRepository(t, 0)
+	defer r.close()
+	r.addChart("redis", strings.Repeat("0", 64))
+	cache := NewChartCache(filepath.Join(r.dir, "cache"), 0, 0)
+
+	_, err := r.load(cache, "redis")
+	if err == nil || !strings.Contains(err.Error(), "digest") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+	files, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Errorf("expected nothing to be cached, got %s", file.Name())
+	}
+}
+
+func TestChartCacheEviction(t *testing.T) {
+	r := newTestRepository(t, 0)
+	defer r.close()
+	for _, name := range []string{"redis", "mysql", "kafka"} {
+		r.addChart(name, "")
+	}
+
+	t.Run("least recently used", func(t *testing.T) {
+		var size int64
+		for _, name := range []string{"redis", "mysql", "kafka"} {
+			info, err := os.Stat(filepath.Join(r.dir, "charts", name+"-1.0.0.tgz"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() > size {
+				size = info.Size()
+			}
+		}
+		// Two archives fit into the cache, but not three.
+		cache := NewChartCache(filepath.Join(r.dir, "lru"), 2*size+size/2, 0)
+
+		for _, name := range []string{"redis", "mysql", "redis", "kafka"} {
+			if _, err := r.load(cache, name); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for name, expected := range map[string]bool{"redis": true, "mysql": false, "kafka": true} {
+			if r.cached(cache, name) != expected {
+				t.Errorf("expected chart %s to be cached: %v", name, expected)
+			}
+		}
+	})
+
+	t.Run("maximum age", func(t *testing.T) {
+		cache := NewChartCache(filepath.Join(r.dir, "age"), 0, time.Hour)
+		if _, err := r.load(cache, "redis"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		past := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(filepath.Join(cache.dir, r.digests["redis"]+chartArchiveExt), past, past); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := r.load(cache, "mysql"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if r.cached(cache, "redis") {
+			t.Error("expected expired chart to be evicted")
+		}
+		if !r.cached(cache, "mysql") {
+			t.Error("expected used chart to be cached")
+		}
+	})
+}
@@ -1,33 +1,105 @@
 package helm
 
 import (
-	"errors"
-
 	"google.golang.org/grpc"
-	"k8s.io/helm/pkg/helm"
+	"google.golang.org/grpc/codes"
 	"k8s.io/helm/pkg/helm/environment"
 	"k8s.io/helm/pkg/helm/helmpath"
 )
 
+// DefaultTimeout is the default timeout in seconds for release operations.
+const DefaultTimeout int64 = 300
+
+// ReleaseOptions holds the options for release operations.
+type ReleaseOptions struct {
+	// ChartVersion is the version of the chart to install or upgrade to. The
+	// latest version is used if it is empty.
+	ChartVersion string
+	// DryRun renders the release without installing or upgrading it.
+	DryRun bool
+	// Timeout in seconds for any individual Kubernetes operation.
+	Timeout int64
+	// Wait until all workloads of the release are ready before the operation
+	// reports success. Only used by install and upgrade.
+	Wait bool
+	// RegistryRewrites are the rules rewriting the registries of the images
+	// of the release. Only used by install and upgrade, and defaults to the
+	// rules of the client.
+	RegistryRewrites RegistryRewrites
+}
+
 // Client manages client side of helm.
 type Client struct {
-	// client for helm.
-	client helm.Interface
+	// backend manages the releases.
+	backend Backend
 	// settings describes all of the environment settings.
 	settings environment.EnvSettings
+	// retryPolicy holds the settings of retries of calls which failed with
+	// a transient error.
+	retryPolicy RetryPolicy
+	// chartCache caches the charts downloaded from chart repositories, or is
+	// nil if charts are downloaded into the archive of the Helm home.
+	chartCache *ChartCache
+	// bundle holds the charts of the client if charts are served from an
+	// offline bundle instead of chart repositories.
+	bundle *Bundle
+	// registryRewrites are the rules rewriting the registries of the images
+	// of releases.
+	registryRewrites RegistryRewrites
+	// sources serve the charts which are not in chart repositories.
+	sources []ChartSource
 }
 
-// NewClient creates a new helm client.
+// NewClient creates a new helm client which manages releases through Tiller.
 func NewClient(host string, home string) *Client {
-	cli := &Client{
-		client: helm.NewClient(helm.Host(host)),
+	cli := NewClientWithBackend(NewTillerBackend(host), home)
+	cli.settings.TillerHost = host
+
+	return cli
+}
+
+// NewClientWithBackend creates a new helm client which manages releases
+// through the backend.
+func NewClientWithBackend(backend Backend, home string) *Client {
+	return &Client{
+		backend: backend,
 		settings: environment.EnvSettings{
-			TillerHost: host,
-			Home:       helmpath.Home(home),
+			Home: helmpath.Home(home),
 		},
+		retryPolicy: DefaultRetryPolicy,
 	}
+}
 
-	return cli
+// SetRetryPolicy sets the retry policy of the client.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// SetChartCache sets the cache of the charts downloaded by the client.
+func (c *Client) SetChartCache(cache *ChartCache) {
+	c.chartCache = cache
+}
+
+// SetBundle sets the offline bundle the client searches and loads charts from.
+func (c *Client) SetBundle(bundle *Bundle) {
+	c.bundle = bundle
+}
+
+// SetRegistryRewrites sets the rules rewriting the registries of the images of
+// the releases installed and upgraded by the client.
+func (c *Client) SetRegistryRewrites(rewrites RegistryRewrites) {
+	c.registryRewrites = rewrites
+}
+
+// tillerError is an error returned by Tiller in a user-friendly form, which
+// keeps the gRPC code of the error.
+type tillerError struct {
+	code        codes.Code
+	description string
+}
+
+func (e *tillerError) Error() string {
+	return e.description
 }
 
 // prettyError unwraps or rewrites certain errors to make them more user-friendly.
@@ -36,5 +108,5 @@ func prettyError(err error) error {
 		return nil
 	}
 
-	return errors.New(grpc.ErrorDesc(err))
+	return &tillerError{code: grpc.Code(err), description: grpc.ErrorDesc(err)}
 }
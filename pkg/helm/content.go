@@ -0,0 +1,39 @@
+package helm
+
+import (
+	"context"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// ReleaseContent returns the chart, config and rendered manifest of the
+// latest revision of the given release.
+func (c *Client) ReleaseContent(ctx context.Context, name string) (*services.GetReleaseContentResponse, error) {
+	var resp *services.GetReleaseContentResponse
+	err := c.retryPolicy.retry(ctx, "content", func() (err error) {
+		resp, err = c.backend.ReleaseContent(ctx, name)
+		return err
+	}, isTransientError)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ReleaseValues returns the values the latest revision of the given release was
+// installed or upgraded with, without the defaults of its chart.
+func (c *Client) ReleaseValues(ctx context.Context, name string) (map[string]interface{}, error) {
+	resp, err := c.ReleaseContent(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := chartutil.ReadValues([]byte(resp.GetRelease().GetConfig().GetRaw()))
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
@@ -1,19 +1,16 @@
 package helm
 
 import (
-	"k8s.io/helm/pkg/helm"
+	"context"
+
 	"k8s.io/helm/pkg/proto/hapi/services"
 )
 
 // DeleteRelease uninstalls a named release and returns the response.
-func (c *Client) DeleteRelease(name string) (*services.UninstallReleaseResponse, error) {
-	resp, err := c.client.DeleteRelease(
-		name,
-		helm.DeletePurge(true),
-		helm.DeleteTimeout(300),
-	)
+func (c *Client) DeleteRelease(ctx context.Context, name string, opts ReleaseOptions) (*services.UninstallReleaseResponse, error) {
+	resp, err := c.backend.DeleteRelease(ctx, name, opts)
 	if err != nil {
-		return nil, prettyError(err)
+		return nil, err
 	}
 
 	return resp, nil
@@ -0,0 +1,230 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// GitCommitAnnotation is the annotation of the charts of Git sources holding
+// the commit the chart was packaged from. It is kept in the chart of the
+// releases installed from the chart.
+const GitCommitAnnotation = "helm-broker.io/git-commit"
+
+// GitChartSource is a ChartSource which serves the chart directories of a
+// Git repository at a ref. The charts of the commit the ref points to are
+// packaged with chartutil.Save when the source is refreshed.
+type GitChartSource struct {
+	// name the charts of the source are referenced with.
+	name string
+	// repository is the path of the checkout or bare repository.
+	repository string
+	// ref is the branch, tag or commit the charts are read at.
+	ref string
+	// path is the directory of the repository the charts are found in.
+	path string
+	// workDir is the directory the charts are packaged in.
+	workDir string
+
+	// mutex guards the fields below, and is held for reading while charts
+	// are loaded.
+	mutex sync.RWMutex
+	// commit is the commit the charts were packaged from.
+	commit string
+	// packageDir is the directory of the packaged charts.
+	packageDir string
+	// index of the packaged charts.
+	index *repo.IndexFile
+}
+
+var _ ChartSource = &GitChartSource{}
+
+// NewGitChartSource creates a ChartSource with the name which serves the charts
+// found in the path of a Git repository at the ref. The charts are packaged in
+// the work directory.
+func NewGitChartSource(name, repository, ref, path, workDir string) *GitChartSource {
+	return &GitChartSource{
+		name:       name,
+		repository: repository,
+		ref:        ref,
+		path:       path,
+		workDir:    workDir,
+	}
+}
+
+func (s *GitChartSource) Name() string {
+	return s.name
+}
+
+func (s *GitChartSource) Index() (*repo.IndexFile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.index == nil {
+		return nil, errors.New("charts have not been packaged yet")
+	}
+	return s.index, nil
+}
+
+func (s *GitChartSource) Load(ctx context.Context, name, version string) (*chart.Chart, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.index == nil {
+		return nil, errors.New("charts have not been packaged yet")
+	}
+	cv, err := s.index.Get(name, version)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := chartutil.LoadFile(filepath.Join(s.packageDir, cv.URLs[0]))
+	if err != nil {
+		return nil, prettyError(err)
+	}
+	glog.V(4).Infof("loaded chart %s/%s-%s of commit %s", s.name, name, cv.Version, s.commit)
+	return ch, nil
+}
+
+// Poll refreshes the source in the interval until the context is done.
+func (s *GitChartSource) Poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				glog.Warningf("failed to refresh chart source %s: %v", s.name, err)
+			}
+		}
+	}
+}
+
+// Refresh packages the charts of the commit the ref points to, if it changed
+// since the charts were packaged.
+func (s *GitChartSource) Refresh(ctx context.Context) error {
+	out, err := s.git(ctx, "rev-parse", "--verify", s.ref+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", s.ref, err)
+	}
+	commit := strings.TrimSpace(string(out))
+
+	s.mutex.RLock()
+	current := s.commit
+	s.mutex.RUnlock()
+	if commit == current {
+		return nil
+	}
+
+	packageDir, index, err := s.packageCharts(ctx, commit)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	oldDir := s.packageDir
+	s.commit, s.packageDir, s.index = commit, packageDir, index
+	s.mutex.Unlock()
+
+	if oldDir != "" {
+		os.RemoveAll(oldDir)
+	}
+	glog.Infof("packaged charts of chart source %s at commit %s", s.name, commit)
+	return nil
+}
+
+// packageCharts exports the path of the commit, and packages every chart
+// directory in it with the commit annotation into a new package directory.
+func (s *GitChartSource) packageCharts(ctx context.Context, commit string) (string, *repo.IndexFile, error) {
+	if err := os.MkdirAll(s.workDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	exportDir, err := ioutil.TempDir(s.workDir, ".export-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	defer os.RemoveAll(exportDir)
+
+	args := []string{"archive", "--format=tar", commit}
+	if s.path != "" {
+		args = append(args, "--", s.path)
+	}
+	archive, err := s.git(ctx, args...)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to export commit %s: %v", commit, err)
+	}
+	if err := extractTar(bytes.NewReader(archive), exportDir); err != nil {
+		return "", nil, fmt.Errorf("failed to export commit %s: %v", commit, err)
+	}
+
+	packageDir, err := ioutil.TempDir(s.workDir, commit[:12]+"-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create work directory: %v", err)
+	}
+	index := repo.NewIndexFile()
+	err = filepath.Walk(exportDir, func(dir string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(dir, chartutil.ChartfileName)); err != nil {
+			return nil
+		}
+
+		ch, err := chartutil.LoadDir(dir)
+		if err != nil {
+			glog.Warningf("failed to load chart %s of commit %s: %v", strings.TrimPrefix(dir, exportDir), commit, err)
+			return filepath.SkipDir
+		}
+		if ch.Metadata.Annotations == nil {
+			ch.Metadata.Annotations = map[string]string{}
+		}
+		ch.Metadata.Annotations[GitCommitAnnotation] = commit
+
+		file, err := chartutil.Save(ch, packageDir)
+		if err != nil {
+			return fmt.Errorf("failed to package chart %s: %v", ch.Metadata.Name, err)
+		}
+		digest, err := digestFile(file)
+		if err != nil {
+			return err
+		}
+		index.Add(ch.Metadata, filepath.Base(file), "", digest)
+		// Dependencies of the chart are packaged with it.
+		return filepath.SkipDir
+	})
+	if err != nil {
+		os.RemoveAll(packageDir)
+		return "", nil, err
+	}
+	index.SortEntries()
+	return packageDir, index, nil
+}
+
+// git runs a git command in the repository and returns its output.
+func (s *GitChartSource) git(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", s.repository}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -1,6 +1,7 @@
 package helm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -11,45 +12,83 @@ import (
 	"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/downloader"
 	"k8s.io/helm/pkg/getter"
-	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/proto/hapi/services"
 	"k8s.io/helm/pkg/repo"
 )
 
 // InstallRelease loads a chart, installs it, and returns the release response.
-func (c *Client) InstallRelease(chart string, namespace string, name string, values map[string]interface{}) (*services.InstallReleaseResponse, error) {
+func (c *Client) InstallRelease(ctx context.Context, chart string, namespace string, name string, values map[string]interface{}, opts ReleaseOptions) (*services.InstallReleaseResponse, error) {
 	rawValues, err := yaml.Marshal(values)
 	if err != nil {
 		return nil, err
 	}
 
-	chartPath, err := locateChartPath("", "", "", chart, "", false, "", "", "", "", c.settings)
+	if opts.RegistryRewrites == nil {
+		opts.RegistryRewrites = c.registryRewrites
+	}
+
+	chartRequested, err := c.loadChart(ctx, chart, opts.ChartVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	chartRequested, err := chartutil.Load(chartPath)
+	// The install may have reached Tiller before it failed, so it is only
+	// retried if the release does not exist.
+	var resp *services.InstallReleaseResponse
+	err = c.retryPolicy.retry(ctx, "install", func() (err error) {
+		resp, err = c.backend.InstallRelease(ctx, chartRequested, namespace, name, rawValues, opts)
+		return err
+	}, func(err error) bool {
+		if !isTransientError(err) {
+			return false
+		}
+		_, err = c.backend.ReleaseHistory(ctx, name, 1)
+		return isReleaseNotFound(name, err)
+	})
 	if err != nil {
-		return nil, prettyError(err)
+		return nil, err
 	}
 
-	resp, err := c.client.InstallReleaseFromChart(
-		chartRequested,
-		namespace,
-		helm.ReleaseName(name),
-		helm.ValueOverrides(rawValues),
-		helm.InstallTimeout(300),
-	)
+	return resp, nil
+}
+
+// loadChart locates and loads a chart. Charts of chart sources are loaded from
+// their source. Charts of chart repositories are loaded from the bundle if the
+// client has one, and through the chart cache if the client has one.
+func (c *Client) loadChart(ctx context.Context, name, version string) (*chart.Chart, error) {
+	if source, chartName := c.chartSource(name); source != nil {
+		return source.Load(ctx, chartName, strings.TrimSpace(version))
+	}
+	if c.bundle != nil && !isLocalChart(c.settings, strings.TrimSpace(name)) {
+		return c.bundle.load(name, version)
+	}
+	if c.chartCache != nil {
+		ch, err := c.chartCache.load(ctx, c.retryPolicy, c.settings, name, version)
+		if err != errNotCacheable {
+			return ch, err
+		}
+	}
+
+	chartPath, err := locateChartPath(ctx, c.retryPolicy, "", "", "", name, version, false, "", "", "", "", c.settings)
 	if err != nil {
-		return nil, prettyError(err)
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	return resp, nil
+	ch, err := chartutil.Load(chartPath)
+	if err != nil {
+		return nil, prettyError(err)
+	}
+	return ch, nil
 }
 
 // locateChartPath looks for a chart directory in known places, and returns either the full path or an error.
-func locateChartPath(repoURL, username, password, name, version string, verify bool, keyring,
+// Downloads which failed with a transient error are retried with the policy.
+func locateChartPath(ctx context.Context, policy RetryPolicy, repoURL, username, password, name, version string, verify bool, keyring,
 	certFile, keyFile, caFile string, settings environment.EnvSettings) (string, error) {
 	name = strings.TrimSpace(name)
 	version = strings.TrimSpace(version)
@@ -101,7 +140,11 @@ func locateChartPath(repoURL, username, password, name, version string, verify b
 		os.MkdirAll(settings.Home.Archive(), 0744)
 	}
 
-	filename, _, err := dl.DownloadTo(name, version, settings.Home.Archive())
+	var filename string
+	err := policy.retry(ctx, "download", func() (err error) {
+		filename, _, err = dl.DownloadTo(name, version, settings.Home.Archive())
+		return err
+	}, isTransientDownloadError)
 	if err == nil {
 		lname, err := filepath.Abs(filename)
 		if err != nil {
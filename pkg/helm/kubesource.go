@@ -0,0 +1,138 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// Kinds of the objects charts are stored in.
+const (
+	configMapKind = "configmap"
+	secretKind    = "secret"
+)
+
+// kubeChartSource is a ChartSource which serves the chart archives stored in
+// labelled ConfigMaps and Secrets. Every key of the binary data of a ConfigMap
+// or of the data of a Secret ending with .tgz holds a chart archive. The URL
+// of a chart in the index is kind/namespace/name/key of its object.
+type kubeChartSource struct {
+	name      string
+	client    kubeclientset.Interface
+	namespace string
+	selector  string
+}
+
+// NewKubeChartSource creates a ChartSource with the name which serves the
+// charts stored in the ConfigMaps and Secrets of the namespace matching the
+// label selector.
+func NewKubeChartSource(name string, client kubeclientset.Interface, namespace string, selector string) ChartSource {
+	return &kubeChartSource{
+		name:      name,
+		client:    client,
+		namespace: namespace,
+		selector:  selector,
+	}
+}
+
+func (s *kubeChartSource) Name() string {
+	return s.name
+}
+
+// Index lists the labelled objects and indexes the archives they hold.
+// Archives which cannot be loaded are left out.
+func (s *kubeChartSource) Index() (*repo.IndexFile, error) {
+	opts := metav1.ListOptions{LabelSelector: s.selector}
+	configMaps, err := s.client.CoreV1().ConfigMaps(s.namespace).List(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chart config maps: %v", err)
+	}
+	secrets, err := s.client.CoreV1().Secrets(s.namespace).List(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chart secrets: %v", err)
+	}
+
+	index := repo.NewIndexFile()
+	for _, cm := range configMaps.Items {
+		s.add(index, configMapKind, cm.Namespace, cm.Name, cm.BinaryData)
+	}
+	for _, secret := range secrets.Items {
+		s.add(index, secretKind, secret.Namespace, secret.Name, secret.Data)
+	}
+	index.SortEntries()
+	return index, nil
+}
+
+// add adds the archives of an object to the index.
+func (s *kubeChartSource) add(index *repo.IndexFile, kind, namespace, name string, data map[string][]byte) {
+	for key, archive := range data {
+		if !strings.HasSuffix(key, ".tgz") {
+			continue
+		}
+		url := strings.Join([]string{kind, namespace, name, key}, "/")
+		ch, err := chartutil.LoadArchive(bytes.NewReader(archive))
+		if err != nil {
+			glog.Warningf("failed to load chart %s: %v", url, err)
+			continue
+		}
+		index.Add(ch.Metadata, url, "", digestBytes(archive))
+	}
+}
+
+// Load looks up the chart in the index and loads its archive from its object.
+func (s *kubeChartSource) Load(ctx context.Context, name, version string) (*chart.Chart, error) {
+	index, err := s.Index()
+	if err != nil {
+		return nil, err
+	}
+	cv, err := index.Get(name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(cv.URLs[0], "/", 4)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid chart object %q", cv.URLs[0])
+	}
+	var data map[string][]byte
+	switch parts[0] {
+	case configMapKind:
+		cm, err := s.client.CoreV1().ConfigMaps(parts[1]).Get(parts[2], metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chart config map %s: %v", parts[2], err)
+		}
+		data = cm.BinaryData
+	case secretKind:
+		secret, err := s.client.CoreV1().Secrets(parts[1]).Get(parts[2], metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chart secret %s: %v", parts[2], err)
+		}
+		data = secret.Data
+	}
+
+	archive, ok := data[parts[3]]
+	if !ok || digestBytes(archive) != cv.Digest {
+		return nil, fmt.Errorf("chart %s-%s changed while it was loaded", name, cv.Version)
+	}
+	ch, err := chartutil.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, prettyError(err)
+	}
+	return ch, nil
+}
+
+// digestBytes returns the hex-encoded SHA-256 digest of data.
+func digestBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,184 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+)
+
+// resourceClient applies and deletes the resources of manifests through the
+// REST API of Kubernetes, for any kind served by the cluster.
+type resourceClient struct {
+	discovery discovery.DiscoveryInterface
+
+	mutex sync.Mutex
+	// resources caches the API resources by API version and kind.
+	resources map[string]apiResource
+}
+
+// apiResource is the REST resource of a kind.
+type apiResource struct {
+	name       string
+	namespaced bool
+}
+
+func newResourceClient(discovery discovery.DiscoveryInterface) *resourceClient {
+	return &resourceClient{
+		discovery: discovery,
+		resources: map[string]apiResource{},
+	}
+}
+
+// resource returns the REST resource of a kind.
+func (c *resourceClient) resource(apiVersion string, kind string) (apiResource, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := apiVersion + "/" + kind
+	if resource, ok := c.resources[key]; ok {
+		return resource, nil
+	}
+
+	resourceList, err := c.discovery.ServerResourcesForGroupVersion(apiVersion)
+	if err != nil {
+		return apiResource{}, fmt.Errorf("failed to discover resources of %s: %v", apiVersion, err)
+	}
+	for _, r := range resourceList.APIResources {
+		if r.Kind == kind && !strings.Contains(r.Name, "/") {
+			resource := apiResource{name: r.Name, namespaced: r.Namespaced}
+			c.resources[key] = resource
+			return resource, nil
+		}
+	}
+	return apiResource{}, fmt.Errorf("kind %s is not served by %s", kind, apiVersion)
+}
+
+// paths returns the REST paths of the collection and the item of the resource
+// of a document. The namespace is used for namespaced resources without a
+// namespace.
+func (c *resourceClient) paths(doc *document, namespace string) (string, string, string, error) {
+	resource, err := c.resource(doc.header.APIVersion, doc.header.Kind)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	collection := "/apis/" + doc.header.APIVersion
+	if doc.header.APIVersion == "v1" {
+		collection = "/api/v1"
+	}
+	ns := ""
+	if resource.namespaced {
+		ns = doc.header.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		collection += "/namespaces/" + ns
+	}
+	collection += "/" + resource.name
+	return collection, collection + "/" + doc.header.Metadata.Name, ns, nil
+}
+
+// apply creates the resource of a document, or patches the live resource with
+// the changes from the original document of the resource. Fields which were
+// removed from the original document are removed from the live resource.
+func (c *resourceClient) apply(ctx context.Context, original *document, modified *document, namespace string) error {
+	collection, item, ns, err := c.paths(modified, namespace)
+	if err != nil {
+		return err
+	}
+
+	object, err := toObject(modified.content)
+	if err != nil {
+		return err
+	}
+	if ns != "" {
+		object["metadata"].(map[string]interface{})["namespace"] = ns
+	}
+
+	client := c.discovery.RESTClient()
+	err = client.Get().AbsPath(item).Context(ctx).Do().Error()
+	if apierrors.IsNotFound(err) {
+		body, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if err := client.Post().AbsPath(collection).Body(body).Context(ctx).Do().Error(); err != nil {
+			return fmt.Errorf("failed to create %s: %v", modified.key(), err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get %s: %v", modified.key(), err)
+	}
+
+	var originalObject map[string]interface{}
+	if original != nil {
+		if originalObject, err = toObject(original.content); err != nil {
+			return err
+		}
+	}
+	patch, err := json.Marshal(mergePatch(originalObject, object))
+	if err != nil {
+		return err
+	}
+	if err := client.Patch(types.MergePatchType).AbsPath(item).Body(patch).Context(ctx).Do().Error(); err != nil {
+		return fmt.Errorf("failed to patch %s: %v", modified.key(), err)
+	}
+	return nil
+}
+
+// delete deletes the resource of a document. Resources which do not exist are
+// ignored.
+func (c *resourceClient) delete(ctx context.Context, doc *document, namespace string) error {
+	_, item, _, err := c.paths(doc, namespace)
+	if err != nil {
+		return err
+	}
+
+	body := []byte(`{"kind":"DeleteOptions","apiVersion":"v1","propagationPolicy":"Background"}`)
+	err = c.discovery.RESTClient().Delete().AbsPath(item).Body(body).Context(ctx).Do().Error()
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s: %v", doc.key(), err)
+	}
+	return nil
+}
+
+// toObject decodes the content of a document.
+func toObject(content string) (map[string]interface{}, error) {
+	object := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(content), &object); err != nil {
+		return nil, err
+	}
+	if _, ok := object["metadata"].(map[string]interface{}); !ok {
+		object["metadata"] = map[string]interface{}{}
+	}
+	return object, nil
+}
+
+// mergePatch returns a JSON merge patch which sets all fields of the modified
+// object, and removes the fields of the original object which are not in the
+// modified object.
+func mergePatch(original map[string]interface{}, modified map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{}, len(modified))
+	for k, v := range modified {
+		vMap, vOK := v.(map[string]interface{})
+		oMap, oOK := original[k].(map[string]interface{})
+		if vOK && oOK {
+			patch[k] = mergePatch(oMap, vMap)
+		} else {
+			patch[k] = v
+		}
+	}
+	for k := range original {
+		if _, ok := modified[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
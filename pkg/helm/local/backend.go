@@ -0,0 +1,467 @@
+// Package local implements a helm.Backend which installs releases without
+// Tiller. Charts are rendered in the broker and the rendered resources are
+// applied through the REST API of Kubernetes.
+package local // import "github.com/huangjiuyuan/helm-broker/pkg/helm/local"
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+	"github.com/huangjiuyuan/helm-broker/pkg/kube"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// pollInterval is the interval in which the readiness of releases is polled.
+const pollInterval = 2 * time.Second
+
+// Backend is a helm.Backend which renders charts with the template engine of
+// Tiller, and applies the rendered resources itself. The revisions of releases
+// are stored in Secrets. Hooks are not run.
+type Backend struct {
+	// Clientset for kubernetes.
+	client kubeclientset.Interface
+	// resources applies the rendered resources.
+	resources *resourceClient
+	// storage keeps the revisions of releases.
+	storage *storage
+
+	// mutex guards locks.
+	mutex sync.Mutex
+	// locks holds the locks of the releases which are operated on, by name.
+	locks map[string]*releaseLock
+}
+
+// releaseLock serializes the operations on a release.
+type releaseLock struct {
+	sync.Mutex
+	// refs is the number of operations on the release.
+	refs int
+}
+
+var _ helm.Backend = &Backend{}
+
+// NewBackend creates a Backend which stores releases in Secrets of the
+// namespace.
+func NewBackend(client kubeclientset.Interface, namespace string) *Backend {
+	return &Backend{
+		client:    client,
+		resources: newResourceClient(client.Discovery()),
+		storage: &storage{
+			client:    client,
+			namespace: namespace,
+		},
+		locks: make(map[string]*releaseLock),
+	}
+}
+
+// lock acquires the lock of a release.
+func (b *Backend) lock(name string) *releaseLock {
+	b.mutex.Lock()
+	lock, ok := b.locks[name]
+	if !ok {
+		lock = &releaseLock{}
+		b.locks[name] = lock
+	}
+	lock.refs++
+	b.mutex.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+// unlock releases the lock of a release, and removes it once no operation
+// holds it.
+func (b *Backend) unlock(name string, lock *releaseLock) {
+	lock.Unlock()
+
+	b.mutex.Lock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(b.locks, name)
+	}
+	b.mutex.Unlock()
+}
+
+// InstallRelease renders a chart and applies the rendered resources as the
+// first revision of a new release. As in Tiller, the name of a release whose
+// latest revision failed or was deleted is reused, and the release is
+// installed as the next revision over it.
+func (b *Backend) InstallRelease(ctx context.Context, ch *chart.Chart, namespace string, name string, rawValues []byte, opts helm.ReleaseOptions) (*services.InstallReleaseResponse, error) {
+	lock := b.lock(name)
+	defer b.unlock(name, lock)
+
+	var replaced *release.Release
+	version := int32(1)
+	releases, err := b.storage.history(name)
+	if err == nil {
+		replaced = releases[0]
+		if code := replaced.GetInfo().GetStatus().GetCode(); code != release.Status_FAILED && code != release.Status_DELETED {
+			return nil, fmt.Errorf("a release named %s already exists", name)
+		}
+		version = replaced.Version + 1
+	} else if !isReleaseNotFound(name, err) {
+		return nil, err
+	}
+
+	rel, err := b.newRelease(ch, namespace, name, rawValues, version, true, opts.RegistryRewrites)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		rel.Info.Description = "Dry run complete"
+		return &services.InstallReleaseResponse{Release: rel}, nil
+	}
+
+	rel.Info.Status.Code = release.Status_PENDING_INSTALL
+	if err := b.storage.create(rel); err != nil {
+		return nil, err
+	}
+	// The resources of a failed revision which were applied are updated, and
+	// those of a deleted revision are gone.
+	var current *release.Release
+	if replaced.GetInfo().GetStatus().GetCode() == release.Status_FAILED {
+		current = replaced
+	}
+	if err := b.deploy(ctx, current, rel, opts); err != nil {
+		return nil, b.fail(rel, fmt.Errorf("release %s failed: %v", name, err))
+	}
+
+	rel.Info.Description = describe("Install complete", rel)
+	if err := b.succeed(rel, releases); err != nil {
+		return nil, err
+	}
+	if replaced != nil {
+		replaced.Info.Status.Code = release.Status_SUPERSEDED
+		if err := b.storage.update(replaced); err != nil {
+			return nil, err
+		}
+	}
+	return &services.InstallReleaseResponse{Release: rel}, nil
+}
+
+// UpdateRelease renders a chart and applies the rendered resources as a new
+// revision of a release.
+func (b *Backend) UpdateRelease(ctx context.Context, ch *chart.Chart, name string, rawValues []byte, opts helm.ReleaseOptions) (*services.UpdateReleaseResponse, error) {
+	lock := b.lock(name)
+	defer b.unlock(name, lock)
+
+	releases, err := b.storage.history(name)
+	if err != nil {
+		return nil, err
+	}
+	current := releases[0]
+
+	rel, err := b.newRelease(ch, current.Namespace, name, rawValues, current.Version+1, false, opts.RegistryRewrites)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		rel.Info.Description = "Dry run complete"
+		return &services.UpdateReleaseResponse{Release: rel}, nil
+	}
+
+	rel.Info.Status.Code = release.Status_PENDING_UPGRADE
+	if err := b.storage.create(rel); err != nil {
+		return nil, err
+	}
+	if err := b.deploy(ctx, current, rel, opts); err != nil {
+		return nil, b.fail(rel, fmt.Errorf("upgrade %q failed: %v", name, err))
+	}
+
+	rel.Info.Description = describe("Upgrade complete", rel)
+	return &services.UpdateReleaseResponse{Release: rel}, b.succeed(rel, releases)
+}
+
+// RollbackRelease applies the resources of a revision of a release as a new
+// revision.
+func (b *Backend) RollbackRelease(ctx context.Context, name string, revision int32, opts helm.ReleaseOptions) (*services.RollbackReleaseResponse, error) {
+	lock := b.lock(name)
+	defer b.unlock(name, lock)
+
+	releases, err := b.storage.history(name)
+	if err != nil {
+		return nil, err
+	}
+	current := releases[0]
+	if revision == 0 {
+		revision = current.Version - 1
+	}
+
+	var target *release.Release
+	for _, r := range releases {
+		if r.Version == revision {
+			target = r
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("release %s has no revision %d", name, revision)
+	}
+
+	now := ptypes.TimestampNow()
+	rel := &release.Release{
+		Name:      name,
+		Namespace: current.Namespace,
+		Chart:     target.Chart,
+		Config:    target.Config,
+		Manifest:  target.Manifest,
+		Version:   current.Version + 1,
+		Info: &release.Info{
+			FirstDeployed: current.GetInfo().GetFirstDeployed(),
+			LastDeployed:  now,
+			Status:        &release.Status{Code: release.Status_PENDING_ROLLBACK},
+			Description:   fmt.Sprintf("Rollback to %d", revision),
+		},
+	}
+	if err := b.storage.create(rel); err != nil {
+		return nil, err
+	}
+	if err := b.deploy(ctx, current, rel, opts); err != nil {
+		return nil, b.fail(rel, fmt.Errorf("rollback %q failed: %v", name, err))
+	}
+
+	return &services.RollbackReleaseResponse{Release: rel}, b.succeed(rel, releases)
+}
+
+// DeleteRelease deletes the resources of a release and all its revisions.
+func (b *Backend) DeleteRelease(ctx context.Context, name string, opts helm.ReleaseOptions) (*services.UninstallReleaseResponse, error) {
+	lock := b.lock(name)
+	defer b.unlock(name, lock)
+
+	releases, err := b.storage.history(name)
+	if err != nil {
+		return nil, err
+	}
+	current := releases[0]
+
+	docs, err := splitManifest(current.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(docs) - 1; i >= 0; i-- {
+		if err := b.resources.delete(ctx, docs[i], current.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.storage.deleteAll(releases); err != nil {
+		return nil, err
+	}
+
+	current.Info.Status.Code = release.Status_DELETED
+	current.Info.Deleted = ptypes.TimestampNow()
+	current.Info.Description = "Deletion complete"
+	return &services.UninstallReleaseResponse{Release: current}, nil
+}
+
+// ReleaseStatus returns the status of the latest revision of a release.
+func (b *Backend) ReleaseStatus(ctx context.Context, name string) (*services.GetReleaseStatusResponse, error) {
+	releases, err := b.storage.history(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &services.GetReleaseStatusResponse{
+		Name:      name,
+		Namespace: releases[0].Namespace,
+		Info:      releases[0].Info,
+	}, nil
+}
+
+// ReleaseContent returns the latest revision of a release.
+func (b *Backend) ReleaseContent(ctx context.Context, name string) (*services.GetReleaseContentResponse, error) {
+	releases, err := b.storage.history(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &services.GetReleaseContentResponse{Release: releases[0]}, nil
+}
+
+// ReleaseHistory returns up to max revisions of a release, latest first.
+func (b *Backend) ReleaseHistory(ctx context.Context, name string, max int32) (*services.GetHistoryResponse, error) {
+	releases, err := b.storage.history(name)
+	if err != nil {
+		return nil, err
+	}
+	if int(max) < len(releases) {
+		releases = releases[:max]
+	}
+
+	return &services.GetHistoryResponse{Releases: releases}, nil
+}
+
+// describe returns the description of a revision, which reports the registry
+// rewrites recorded in its chart for auditing.
+func describe(description string, rel *release.Release) string {
+	rewrites := rel.GetChart().GetMetadata().GetAnnotations()[helm.RegistryRewritesAnnotation]
+	if rewrites == "" {
+		return description
+	}
+	return fmt.Sprintf("%s, image registries rewritten: %s", description, rewrites)
+}
+
+// newRelease renders a revision of a release, with the registries of its images
+// rewritten by the registry rewrites. The rules which rewrote an image are
+// recorded in the chart of the revision. The revision is rendered as an install
+// or as an upgrade.
+func (b *Backend) newRelease(ch *chart.Chart, namespace string, name string, rawValues []byte, version int32, install bool, rewrites helm.RegistryRewrites) (*release.Release, error) {
+	now := ptypes.TimestampNow()
+	config := &chart.Config{Raw: string(rawValues)}
+	opts := chartutil.ReleaseOptions{
+		Name:      name,
+		Time:      now,
+		Namespace: namespace,
+		IsInstall: install,
+		IsUpgrade: !install,
+		Revision:  int(version),
+	}
+
+	manifest, err := renderManifest(ch, config, opts, b.capabilities())
+	if err != nil {
+		return nil, err
+	}
+	manifest, applied, err := rewriteImages(manifest, rewrites)
+	if err != nil {
+		return nil, err
+	}
+
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart:     helm.AnnotateRegistryRewrites(ch, applied),
+		Config:    config,
+		Manifest:  manifest,
+		Version:   version,
+		Info: &release.Info{
+			FirstDeployed: now,
+			LastDeployed:  now,
+			Status:        &release.Status{Code: release.Status_UNKNOWN},
+		},
+	}, nil
+}
+
+// capabilities returns the capabilities of the cluster the templates are
+// rendered for.
+func (b *Backend) capabilities() *chartutil.Capabilities {
+	caps := &chartutil.Capabilities{
+		APIVersions: chartutil.DefaultVersionSet,
+		KubeVersion: chartutil.DefaultKubeVersion,
+	}
+
+	if version, err := b.client.Discovery().ServerVersion(); err == nil {
+		caps.KubeVersion = version
+	} else {
+		glog.Warningf("failed to get server version: %v", err)
+	}
+	if groups, err := b.client.Discovery().ServerGroups(); err == nil {
+		versions := chartutil.NewVersionSet("v1")
+		for _, group := range groups.Groups {
+			for _, version := range group.Versions {
+				versions[version.GroupVersion] = struct{}{}
+			}
+		}
+		caps.APIVersions = versions
+	} else {
+		glog.Warningf("failed to get server groups: %v", err)
+	}
+	return caps
+}
+
+// deploy applies the resources of a revision of a release, deletes the
+// resources of the current revision which are not in the new revision, and
+// waits until the resources are ready if requested. Deploying stops when the
+// context is done.
+func (b *Backend) deploy(ctx context.Context, current *release.Release, rel *release.Release, opts helm.ReleaseOptions) error {
+	docs, err := splitManifest(rel.Manifest)
+	if err != nil {
+		return err
+	}
+
+	currentDocs := map[string]*document{}
+	var removed []*document
+	if current != nil {
+		previous, err := splitManifest(current.Manifest)
+		if err != nil {
+			return err
+		}
+		for _, doc := range previous {
+			currentDocs[doc.key()] = doc
+		}
+
+		keys := map[string]bool{}
+		for _, doc := range docs {
+			keys[doc.key()] = true
+		}
+		for i := len(previous) - 1; i >= 0; i-- {
+			if !keys[previous[i].key()] {
+				removed = append(removed, previous[i])
+			}
+		}
+	}
+
+	for _, doc := range docs {
+		if err := b.resources.apply(ctx, currentDocs[doc.key()], doc, rel.Namespace); err != nil {
+			return err
+		}
+	}
+	for _, doc := range removed {
+		if err := b.resources.delete(ctx, doc, rel.Namespace); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Wait {
+		return nil
+	}
+	resources, err := kube.ParseManifest(rel.Manifest)
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(opts.Timeout) * time.Second
+	return wait.PollImmediate(pollInterval, timeout, func() (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		readiness, err := kube.GetReadiness(b.client, rel.Namespace, resources)
+		if err != nil {
+			glog.V(4).Infof("failed to get readiness of release %s: %v", rel.Name, err)
+			return false, nil
+		}
+		return readiness.Ready, nil
+	})
+}
+
+// fail records a failed revision of a release and returns the error.
+func (b *Backend) fail(rel *release.Release, err error) error {
+	rel.Info.Status.Code = release.Status_FAILED
+	rel.Info.Description = err.Error()
+	if updateErr := b.storage.update(rel); updateErr != nil {
+		glog.Errorf("failed to record failure of release %s: %v", rel.Name, updateErr)
+	}
+	return err
+}
+
+// succeed records a deployed revision of a release, and supersedes the
+// previously deployed revisions.
+func (b *Backend) succeed(rel *release.Release, releases []*release.Release) error {
+	for _, r := range releases {
+		if r.GetInfo().GetStatus().GetCode() == release.Status_DEPLOYED {
+			r.Info.Status.Code = release.Status_SUPERSEDED
+			if err := b.storage.update(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	rel.Info.Status.Code = release.Status_DEPLOYED
+	return b.storage.update(rel)
+}
@@ -0,0 +1,90 @@
+package local
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+)
+
+// containerFields are the fields of pod specs holding containers.
+var containerFields = []string{"containers", "initContainers"}
+
+// rewriteImages rewrites the registries of the images of the containers in the
+// documents of a manifest, and returns the rules which rewrote an image in the
+// order of the rules. Documents without rewritten images are kept as rendered.
+func rewriteImages(manifest string, rewrites helm.RegistryRewrites) (string, helm.RegistryRewrites, error) {
+	if len(rewrites) == 0 {
+		return manifest, nil, nil
+	}
+
+	docs, err := splitManifest(manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	applied := map[helm.RegistryRewrite]bool{}
+	for _, doc := range docs {
+		object, err := toObject(doc.content)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse %s: %v", doc.source, err)
+		}
+		if !rewriteContainerImages(object, rewrites, applied) {
+			continue
+		}
+		content, err := yaml.Marshal(object)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to encode %s: %v", doc.source, err)
+		}
+		doc.content = strings.TrimRight(string(content), "\n")
+	}
+
+	var appliedRewrites helm.RegistryRewrites
+	for _, rule := range rewrites {
+		if applied[rule] {
+			appliedRewrites = append(appliedRewrites, rule)
+			delete(applied, rule)
+		}
+	}
+	return joinManifest(docs), appliedRewrites, nil
+}
+
+// rewriteContainerImages rewrites the images of the containers found anywhere
+// in a value, marks the rules which rewrote them as applied, and returns true
+// if an image was rewritten.
+func rewriteContainerImages(value interface{}, rewrites helm.RegistryRewrites, applied map[helm.RegistryRewrite]bool) bool {
+	rewritten := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, field := range containerFields {
+			containers, _ := v[field].([]interface{})
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				image, ok := container["image"].(string)
+				if !ok {
+					continue
+				}
+				if rule, ok := rewrites.Rule(image); ok {
+					container["image"] = rewrites.Rewrite(image)
+					applied[rule] = true
+					rewritten = true
+				}
+			}
+		}
+		for _, child := range v {
+			if rewriteContainerImages(child, rewrites, applied) {
+				rewritten = true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if rewriteContainerImages(child, rewrites, applied) {
+				rewritten = true
+			}
+		}
+	}
+	return rewritten
+}
@@ -0,0 +1,162 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/huangjiuyuan/helm-broker/pkg/helm"
+)
+
+func TestRewriteImages(t *testing.T) {
+	rewrites := helm.RegistryRewrites{
+		{From: "docker.io", To: "mirror.example.com/dockerhub"},
+		{From: "quay.io", To: "mirror.example.com/quay"},
+	}
+	tests := []struct {
+		name     string
+		rewrites helm.RegistryRewrites
+		manifest string
+		expected string
+		applied  string
+	}{
+		{
+			name: "no rules",
+			manifest: `---
+# Source: app/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+spec:
+  containers:
+  - image: redis
+`,
+			expected: `---
+# Source: app/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+spec:
+  containers:
+  - image: redis
+`,
+		},
+		{
+			name:     "pod",
+			rewrites: rewrites,
+			manifest: `---
+# Source: app/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  initContainers:
+  - name: init
+    image: quay.io/coreos/etcd
+  containers:
+  - name: app
+    image: redis:4.0
+`,
+			expected: `---
+# Source: app/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  containers:
+  - image: mirror.example.com/dockerhub/library/redis:4.0
+    name: app
+  initContainers:
+  - image: mirror.example.com/quay/coreos/etcd
+    name: init
+`,
+			applied: "docker.io=>mirror.example.com/dockerhub, quay.io=>mirror.example.com/quay",
+		},
+		{
+			name:     "pod template",
+			rewrites: rewrites,
+			manifest: `---
+# Source: app/templates/cronjob.yaml
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: app
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: app
+            image: bitnami/redis
+`,
+			expected: `---
+# Source: app/templates/cronjob.yaml
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: app
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - image: mirror.example.com/dockerhub/bitnami/redis
+            name: app
+`,
+			applied: "docker.io=>mirror.example.com/dockerhub",
+		},
+		{
+			name:     "unmatched documents are kept as rendered",
+			rewrites: rewrites,
+			manifest: `---
+# Source: app/templates/configmap.yaml
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  image: redis
+---
+# Source: app/templates/deployment.yaml
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - image: gcr.io/google_containers/pause
+        name: pause
+`,
+			expected: `---
+# Source: app/templates/configmap.yaml
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  image: redis
+---
+# Source: app/templates/deployment.yaml
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - image: gcr.io/google_containers/pause
+        name: pause
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, applied, err := rewriteImages(test.manifest, test.rewrites)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected manifest\n%s\ngot\n%s", test.expected, actual)
+			}
+			if applied.String() != test.applied {
+				t.Errorf("expected applied rules %q, got %q", test.applied, applied)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/engine"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// hookAnnotation marks the templates of a chart which are hooks. Hooks are not
+// run by the local backend and are left out of the manifest.
+const hookAnnotation = "helm.sh/hook"
+
+// sourcePrefix starts the comment holding the template of a document in a
+// manifest.
+const sourcePrefix = "# Source: "
+
+// separator splits the documents of a rendered template or manifest.
+var separator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// installOrder is the order in which the kinds of resources are installed, as
+// in Tiller. Resources of other kinds are installed last.
+var installOrder = []string{
+	"Namespace",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ServiceAccount",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+// document is a resource of a rendered manifest.
+type document struct {
+	// source is the path of the template the document was rendered from.
+	source string
+	// content of the document.
+	content string
+	// header holds the fields of the resource the backend works with.
+	header struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name        string            `json:"name"`
+			Namespace   string            `json:"namespace,omitempty"`
+			Annotations map[string]string `json:"annotations,omitempty"`
+		} `json:"metadata"`
+	}
+}
+
+// key identifies the resource of the document.
+func (d *document) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", d.header.APIVersion, d.header.Kind, d.header.Metadata.Namespace, d.header.Metadata.Name)
+}
+
+// parseDocument parses a document of a manifest. Documents without a kind are
+// returned as nil.
+func parseDocument(source string, content string) (*document, error) {
+	content = strings.Trim(content, "\n")
+	if strings.TrimSpace(content) == "" {
+		return nil, nil
+	}
+
+	doc := &document{source: source, content: content}
+	if err := yaml.Unmarshal([]byte(content), &doc.header); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", source, err)
+	}
+	if doc.header.Kind == "" {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// splitManifest returns the documents of a manifest of a release.
+func splitManifest(manifest string) ([]*document, error) {
+	var docs []*document
+	for _, content := range separator.Split(manifest, -1) {
+		source := ""
+		content = strings.TrimLeft(content, "\n")
+		if lines := strings.SplitN(content, "\n", 2); strings.HasPrefix(lines[0], sourcePrefix) && len(lines) == 2 {
+			source, content = strings.TrimPrefix(lines[0], sourcePrefix), lines[1]
+		}
+		doc, err := parseDocument(source, content)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// joinManifest returns the manifest of the documents.
+func joinManifest(docs []*document) string {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		fmt.Fprintf(&buf, "---\n%s%s\n%s\n", sourcePrefix, doc.source, doc.content)
+	}
+	return buf.String()
+}
+
+// kindIndex returns the position of a kind in the install order.
+func kindIndex(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+// renderManifest renders the templates of a chart and its dependencies into
+// the manifest of a release, in install order. Hooks and notes are left out.
+func renderManifest(ch *chart.Chart, config *chart.Config, opts chartutil.ReleaseOptions, caps *chartutil.Capabilities) (string, error) {
+	if err := chartutil.ProcessRequirementsEnabled(ch, config); err != nil {
+		return "", err
+	}
+	if err := chartutil.ProcessRequirementsImportValues(ch); err != nil {
+		return "", err
+	}
+
+	values, err := chartutil.ToRenderValuesCaps(ch, config, opts, caps)
+	if err != nil {
+		return "", err
+	}
+
+	// The templates are rendered by the engine of Tiller, so that charts
+	// render the same with both backends.
+	files, err := engine.New().Render(ch, values)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var docs []*document
+	for _, name := range names {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			continue
+		}
+		for _, content := range separator.Split(files[name], -1) {
+			doc, err := parseDocument(name, content)
+			if err != nil {
+				return "", err
+			}
+			if doc == nil {
+				continue
+			}
+			if _, ok := doc.header.Metadata.Annotations[hookAnnotation]; ok {
+				continue
+			}
+			docs = append(docs, doc)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return kindIndex(docs[i].header.Kind) < kindIndex(docs[j].header.Kind)
+	})
+
+	return joinManifest(docs), nil
+}
@@ -0,0 +1,160 @@
+package local
+
+import (
+	"testing"
+
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestRenderManifest(t *testing.T) {
+	configMap := &chart.Template{
+		Name: "templates/configmap.yaml",
+		Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ template "fullname" . }}
+data:
+  mode: {{ .Values.mode | default "standalone" | quote }}
+  upper: {{ .Release.Name | upper }}`),
+	}
+	helpers := &chart.Template{
+		Name: "templates/_helpers.tpl",
+		Data: []byte(`{{- define "fullname" -}}{{ printf "%s-%s" .Release.Name .Chart.Name | trunc 63 | trimSuffix "-" }}{{- end -}}`),
+	}
+	service := &chart.Template{
+		Name: "templates/service.yaml",
+		Data: []byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}`),
+	}
+	hook := &chart.Template{
+		Name: "templates/job.yaml",
+		Data: []byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ .Release.Name }}-init
+  annotations:
+    helm.sh/hook: post-install`),
+	}
+	notes := &chart.Template{
+		Name: "templates/NOTES.txt",
+		Data: []byte(`Installed {{ .Release.Name }}.`),
+	}
+	required := &chart.Template{
+		Name: "templates/secret.yaml",
+		Data: []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .Release.Name }}
+data:
+  password: {{ required "password is required" .Values.password | b64enc }}`),
+	}
+
+	tests := []struct {
+		name      string
+		templates []*chart.Template
+		values    string
+		expected  string
+		err       bool
+	}{
+		{
+			name:      "sprig functions and partials",
+			templates: []*chart.Template{configMap, helpers},
+			values:    "mode: cluster",
+			expected: `---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-app
+data:
+  mode: "cluster"
+  upper: TEST
+`,
+		},
+		{
+			name:      "defaults",
+			templates: []*chart.Template{configMap, helpers},
+			expected: `---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-app
+data:
+  mode: "standalone"
+  upper: TEST
+`,
+		},
+		{
+			name:      "install order without hooks and notes",
+			templates: []*chart.Template{service, hook, notes, configMap, helpers},
+			expected: `---
+# Source: app/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-app
+data:
+  mode: "standalone"
+  upper: TEST
+---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: test
+`,
+		},
+		{
+			name:      "required value",
+			templates: []*chart.Template{required},
+			values:    "password: secret",
+			expected: `---
+# Source: app/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: test
+data:
+  password: c2VjcmV0
+`,
+		},
+		{
+			name:      "missing required value",
+			templates: []*chart.Template{required},
+			err:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := &chart.Chart{
+				Metadata:  &chart.Metadata{Name: "app", Version: "1.0.0"},
+				Templates: test.templates,
+				Values:    &chart.Config{Raw: ""},
+			}
+			opts := chartutil.ReleaseOptions{Name: "test", Namespace: "default", IsInstall: true, Revision: 1}
+			caps := &chartutil.Capabilities{
+				APIVersions: chartutil.DefaultVersionSet,
+				KubeVersion: chartutil.DefaultKubeVersion,
+			}
+
+			actual, err := renderManifest(ch, &chart.Config{Raw: test.values}, opts, caps)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected error, got manifest\n%s", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected manifest\n%s\ngot\n%s", test.expected, actual)
+			}
+		})
+	}
+}
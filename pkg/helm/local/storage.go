@@ -0,0 +1,132 @@
+package local
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/storage/driver"
+)
+
+const (
+	// owner is the value of the owner label of release Secrets.
+	owner = "HELM-BROKER"
+	// releaseKey is the key of the Secret data holding a release.
+	releaseKey = "release"
+)
+
+// storage keeps the revisions of releases in Secrets of a namespace, labelled
+// like the Secrets of the Tiller Secrets driver.
+type storage struct {
+	// Clientset for kubernetes.
+	client kubeclientset.Interface
+	// Namespace of the Secrets.
+	namespace string
+}
+
+// secretName returns the name of the Secret of a revision of a release.
+func secretName(name string, version int32) string {
+	return fmt.Sprintf("%s.v%d", name, version)
+}
+
+// create stores a new revision of a release.
+func (s *storage) create(rel *release.Release) error {
+	secret, err := newSecret(rel)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.client.CoreV1().Secrets(s.namespace).Create(secret); err != nil {
+		return fmt.Errorf("failed to store release %s: %v", secret.Name, err)
+	}
+	return nil
+}
+
+// update updates a stored revision of a release.
+func (s *storage) update(rel *release.Release) error {
+	secret, err := newSecret(rel)
+	if err != nil {
+		return err
+	}
+
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	current, err := secrets.Get(secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get release %s: %v", secret.Name, err)
+	}
+	current.Labels = secret.Labels
+	current.Data = secret.Data
+	if _, err := secrets.Update(current); err != nil {
+		return fmt.Errorf("failed to update release %s: %v", secret.Name, err)
+	}
+	return nil
+}
+
+// history returns the revisions of a release, latest first.
+func (s *storage) history(name string) ([]*release.Release, error) {
+	secretList, err := s.client.CoreV1().Secrets(s.namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("NAME=%s,OWNER=%s", name, owner),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions of release %s: %v", name, err)
+	}
+	if len(secretList.Items) == 0 {
+		return nil, driver.ErrReleaseNotFound(name)
+	}
+
+	releases := make([]*release.Release, 0, len(secretList.Items))
+	for _, secret := range secretList.Items {
+		rel := &release.Release{}
+		if err := proto.Unmarshal(secret.Data[releaseKey], rel); err != nil {
+			return nil, fmt.Errorf("failed to decode release %s: %v", secret.Name, err)
+		}
+		releases = append(releases, rel)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Version > releases[j].Version
+	})
+	return releases, nil
+}
+
+// isReleaseNotFound returns true if the error is returned by history for a
+// release without revisions.
+func isReleaseNotFound(name string, err error) bool {
+	return err != nil && err.Error() == driver.ErrReleaseNotFound(name).Error()
+}
+
+// deleteAll deletes all revisions of a release.
+func (s *storage) deleteAll(releases []*release.Release) error {
+	for _, rel := range releases {
+		name := secretName(rel.Name, rel.Version)
+		if err := s.client.CoreV1().Secrets(s.namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("failed to delete release %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// newSecret returns the Secret of a revision of a release.
+func newSecret(rel *release.Release) (*corev1.Secret, error) {
+	data, err := proto.Marshal(rel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode release %s: %v", rel.Name, err)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName(rel.Name, rel.Version),
+			Labels: map[string]string{
+				"NAME":    rel.Name,
+				"OWNER":   owner,
+				"STATUS":  rel.GetInfo().GetStatus().GetCode().String(),
+				"VERSION": strconv.Itoa(int(rel.Version)),
+			},
+		},
+		Data: map[string][]byte{releaseKey: data},
+	}, nil
+}
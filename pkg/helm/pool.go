@@ -0,0 +1,63 @@
+package helm
+
+import (
+	"sync"
+)
+
+// Pool holds the clients for helm of all Tillers releases are managed by. The
+// Tiller serving a namespace is chosen by a route, and its client is created
+// on first use.
+type Pool struct {
+	// defaultClient manages the releases of namespaces without a route.
+	defaultClient *Client
+	// route returns the key of the Tiller serving a namespace, or an empty
+	// string if the namespace is served by the default client.
+	route func(namespace string) string
+	// create creates the client for the Tiller with the key.
+	create func(key string) (*Client, error)
+
+	mutex sync.Mutex
+	// clients holds the clients created so far keyed by Tiller.
+	clients map[string]*Client
+}
+
+// NewPool creates a pool of clients for helm. A pool without a route serves
+// all namespaces with the default client.
+func NewPool(defaultClient *Client, route func(namespace string) string, create func(key string) (*Client, error)) *Pool {
+	return &Pool{
+		defaultClient: defaultClient,
+		route:         route,
+		create:        create,
+		clients:       map[string]*Client{},
+	}
+}
+
+// Default returns the default client, which also serves requests not bound to
+// a namespace such as searching the chart repositories.
+func (p *Pool) Default() *Client {
+	return p.defaultClient
+}
+
+// Client returns the client for the Tiller serving the namespace.
+func (p *Pool) Client(namespace string) (*Client, error) {
+	if p.route == nil {
+		return p.defaultClient, nil
+	}
+	key := p.route(namespace)
+	if key == "" {
+		return p.defaultClient, nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if client, ok := p.clients[key]; ok {
+		return client, nil
+	}
+	client, err := p.create(key)
+	if err != nil {
+		return nil, err
+	}
+	p.clients[key] = client
+	return client, nil
+}
@@ -0,0 +1,168 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// RegistryRewritesAnnotation is the annotation of the charts of releases
+// holding the registry rewrites which rewrote images in the rendered manifest
+// of the release, in the form of RegistryRewrites.String. It is kept in the
+// release records for auditing.
+const RegistryRewritesAnnotation = "helm-broker.io/registry-rewrites"
+
+// ImageRegistryAnnotation is the annotation of the charts of releases holding
+// the registry injected as the global.imageRegistry value. It is kept in the
+// release records for auditing, since the descriptions of releases installed
+// by Tiller are set by Tiller.
+const ImageRegistryAnnotation = "helm-broker.io/image-registry"
+
+const (
+	// defaultRegistry is the registry of image references without one.
+	defaultRegistry = "docker.io"
+	// anyRegistry matches the registries of all image references.
+	anyRegistry = "*"
+)
+
+// RegistryRewrite is a rule which rewrites the registry of image references to
+// a mirror.
+type RegistryRewrite struct {
+	// From is the registry rewritten, e.g. "quay.io". "docker.io" matches
+	// references without a registry, and "*" matches all references.
+	From string `json:"from"`
+	// To is the mirror the registry is rewritten to, with an optional path
+	// prefix, e.g. "mirror.example.com/quay".
+	To string `json:"to"`
+}
+
+// RegistryRewrites is a list of rules rewriting image references. The first
+// matching rule is applied.
+type RegistryRewrites []RegistryRewrite
+
+// Rewrite returns the image reference with its registry rewritten by the first
+// matching rule. References to a mirror of the rules are not rewritten.
+func (r RegistryRewrites) Rewrite(image string) string {
+	rule, ok := r.Rule(image)
+	if !ok {
+		return image
+	}
+	_, path := splitImage(image)
+	return strings.TrimSuffix(rule.To, "/") + "/" + path
+}
+
+// Rule returns the rule rewriting an image reference, and false if the
+// reference is not rewritten.
+func (r RegistryRewrites) Rule(image string) (RegistryRewrite, bool) {
+	for _, rule := range r {
+		if strings.HasPrefix(image, strings.TrimSuffix(rule.To, "/")+"/") {
+			return RegistryRewrite{}, false
+		}
+	}
+
+	registry, _ := splitImage(image)
+	for _, rule := range r {
+		if rule.From == anyRegistry || rule.From == registry {
+			return rule, true
+		}
+	}
+	return RegistryRewrite{}, false
+}
+
+// String returns the rules in a form reported in release descriptions.
+func (r RegistryRewrites) String() string {
+	rules := make([]string, len(r))
+	for i, rule := range r {
+		rules[i] = fmt.Sprintf("%s=>%s", rule.From, rule.To)
+	}
+	return strings.Join(rules, ", ")
+}
+
+// GlobalRegistry returns the mirror charts following the global.imageRegistry
+// convention pull all images from. Such charts pull the images of all
+// registries from it, including registries no rule matches, so there is only
+// a global registry if all rules rewrite to the same mirror and a rule matches
+// the default registry.
+func (r RegistryRewrites) GlobalRegistry() string {
+	var registry string
+	var matchesDefault bool
+	for i, rule := range r {
+		to := strings.TrimSuffix(rule.To, "/")
+		if i > 0 && to != registry {
+			return ""
+		}
+		registry = to
+		matchesDefault = matchesDefault || rule.From == anyRegistry || rule.From == defaultRegistry
+	}
+	if !matchesDefault {
+		return ""
+	}
+	return registry
+}
+
+// injectRegistryValues sets the global.imageRegistry value of the raw values
+// to a registry, if it is not empty.
+func injectRegistryValues(rawValues []byte, registry string) ([]byte, error) {
+	if registry == "" {
+		return rawValues, nil
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(rawValues, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values: %v", err)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	global, ok := values["global"].(map[string]interface{})
+	if !ok {
+		global = map[string]interface{}{}
+		values["global"] = global
+	}
+	global["imageRegistry"] = registry
+	return yaml.Marshal(values)
+}
+
+// splitImage splits an image reference into its registry and the rest of the
+// reference. Official images of the default registry are returned with their
+// library path.
+func splitImage(image string) (string, string) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0], parts[1]
+	}
+	if len(parts) == 1 {
+		return defaultRegistry, "library/" + image
+	}
+	return defaultRegistry, image
+}
+
+// AnnotateRegistryRewrites returns a copy of the chart annotated with the
+// registry rewrites which rewrote images of its manifest, or the chart itself
+// if there are none.
+func AnnotateRegistryRewrites(ch *chart.Chart, rewrites RegistryRewrites) *chart.Chart {
+	if len(rewrites) == 0 {
+		return ch
+	}
+	return annotateChart(ch, RegistryRewritesAnnotation, rewrites.String())
+}
+
+// annotateChart returns a copy of the chart with an annotation, or the chart
+// itself if the value is empty.
+func annotateChart(ch *chart.Chart, annotation string, value string) *chart.Chart {
+	if value == "" || ch.GetMetadata() == nil {
+		return ch
+	}
+	metadata := *ch.Metadata
+	metadata.Annotations = make(map[string]string, len(ch.Metadata.Annotations)+1)
+	for k, v := range ch.Metadata.Annotations {
+		metadata.Annotations[k] = v
+	}
+	metadata.Annotations[annotation] = value
+
+	annotated := *ch
+	annotated.Metadata = &metadata
+	return &annotated
+}
@@ -0,0 +1,153 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestSplitImage(t *testing.T) {
+	tests := []struct {
+		image    string
+		registry string
+		path     string
+	}{
+		{image: "redis", registry: "docker.io", path: "library/redis"},
+		{image: "redis:4.0", registry: "docker.io", path: "library/redis:4.0"},
+		{image: "bitnami/redis:4.0", registry: "docker.io", path: "bitnami/redis:4.0"},
+		{image: "docker.io/bitnami/redis", registry: "docker.io", path: "bitnami/redis"},
+		{image: "quay.io/coreos/etcd:v3.3", registry: "quay.io", path: "coreos/etcd:v3.3"},
+		{image: "registry:5000/app", registry: "registry:5000", path: "app"},
+		{image: "localhost/app", registry: "localhost", path: "app"},
+		{image: "gcr.io/google_containers/pause@sha256:abc", registry: "gcr.io", path: "google_containers/pause@sha256:abc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			registry, path := splitImage(test.image)
+			if registry != test.registry || path != test.path {
+				t.Errorf("expected %s and %s, got %s and %s", test.registry, test.path, registry, path)
+			}
+		})
+	}
+}
+
+func TestRegistryRewritesRewrite(t *testing.T) {
+	rewrites := RegistryRewrites{
+		{From: "docker.io", To: "mirror.example.com/dockerhub"},
+		{From: "quay.io", To: "mirror.example.com/quay/"},
+	}
+	tests := []struct {
+		name     string
+		rewrites RegistryRewrites
+		image    string
+		expected string
+	}{
+		{name: "official image", rewrites: rewrites, image: "redis:4.0", expected: "mirror.example.com/dockerhub/library/redis:4.0"},
+		{name: "default registry", rewrites: rewrites, image: "bitnami/redis", expected: "mirror.example.com/dockerhub/bitnami/redis"},
+		{name: "explicit default registry", rewrites: rewrites, image: "docker.io/bitnami/redis", expected: "mirror.example.com/dockerhub/bitnami/redis"},
+		{name: "trailing slash", rewrites: rewrites, image: "quay.io/coreos/etcd", expected: "mirror.example.com/quay/coreos/etcd"},
+		{name: "unmatched registry", rewrites: rewrites, image: "gcr.io/google_containers/pause", expected: "gcr.io/google_containers/pause"},
+		{name: "mirror", rewrites: rewrites, image: "mirror.example.com/quay/coreos/etcd", expected: "mirror.example.com/quay/coreos/etcd"},
+		{
+			name:     "first match",
+			rewrites: RegistryRewrites{{From: "gcr.io", To: "gcr.example.com"}, {From: "*", To: "mirror.example.com"}},
+			image:    "gcr.io/google_containers/pause",
+			expected: "gcr.example.com/google_containers/pause",
+		},
+		{
+			name:     "any registry",
+			rewrites: RegistryRewrites{{From: "gcr.io", To: "gcr.example.com"}, {From: "*", To: "mirror.example.com"}},
+			image:    "quay.io/coreos/etcd",
+			expected: "mirror.example.com/coreos/etcd",
+		},
+		{name: "no rules", image: "redis", expected: "redis"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.rewrites.Rewrite(test.image); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestInjectRegistryValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrites RegistryRewrites
+		values   string
+		expected string
+	}{
+		{
+			name:     "no rules",
+			values:   "replicas: 1\n",
+			expected: "replicas: 1\n",
+		},
+		{
+			name:     "default registry",
+			rewrites: RegistryRewrites{{From: "docker.io", To: "mirror.example.com/"}},
+			values:   "global:\n  storageClass: fast\nreplicas: 1\n",
+			expected: "global:\n  imageRegistry: mirror.example.com\n  storageClass: fast\nreplicas: 1\n",
+		},
+		{
+			name:     "same mirror",
+			rewrites: RegistryRewrites{{From: "quay.io", To: "mirror.example.com"}, {From: "*", To: "mirror.example.com"}},
+			expected: "global:\n  imageRegistry: mirror.example.com\n",
+		},
+		{
+			name:     "different mirrors",
+			rewrites: RegistryRewrites{{From: "docker.io", To: "mirror.example.com/dockerhub"}, {From: "quay.io", To: "mirror.example.com/quay"}},
+			values:   "replicas: 1\n",
+			expected: "replicas: 1\n",
+		},
+		{
+			name:     "default registry not rewritten",
+			rewrites: RegistryRewrites{{From: "quay.io", To: "mirror.example.com"}},
+			values:   "replicas: 1\n",
+			expected: "replicas: 1\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := injectRegistryValues([]byte(test.values), test.rewrites.GlobalRegistry())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(actual) != test.expected {
+				t.Errorf("expected values\n%s\ngot\n%s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAnnotateRegistryRewrites(t *testing.T) {
+	ch := &chart.Chart{Metadata: &chart.Metadata{Name: "redis", Annotations: map[string]string{"a": "b"}}}
+	rewrites := RegistryRewrites{{From: "docker.io", To: "mirror.example.com"}}
+
+	annotated := AnnotateRegistryRewrites(ch, rewrites)
+	if actual := annotated.Metadata.Annotations[RegistryRewritesAnnotation]; actual != "docker.io=>mirror.example.com" {
+		t.Errorf("expected annotation docker.io=>mirror.example.com, got %q", actual)
+	}
+	if annotated.Metadata.Annotations["a"] != "b" {
+		t.Error("expected annotations of the chart to be kept")
+	}
+	if _, ok := ch.Metadata.Annotations[RegistryRewritesAnnotation]; ok {
+		t.Error("expected chart not to be modified")
+	}
+	if AnnotateRegistryRewrites(ch, nil) != ch {
+		t.Error("expected chart without applied rules to be returned as is")
+	}
+	if annotateChart(ch, ImageRegistryAnnotation, "") != ch {
+		t.Error("expected chart without injected registry to be returned as is")
+	}
+
+	// The annotation is valid YAML, as charts are stored with their
+	// metadata.
+	if _, err := yaml.Marshal(annotated.Metadata); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package helm
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"k8s.io/helm/pkg/storage/driver"
+)
+
+// RetryPolicy holds the settings of retries of calls which failed with a
+// transient error, such as a restart of Tiller or a flaky chart repository.
+type RetryPolicy struct {
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries.
+	MaxBackoff time.Duration
+	// Multiplier is the factor the delay is multiplied with after each
+	// retry.
+	Multiplier float64
+	// Jitter is the maximum fraction of the delay which is added randomly,
+	// so that clients do not retry in lockstep.
+	Jitter float64
+	// Budget is the maximum time a call and its retries may take. Calls are
+	// not retried if it is zero.
+	Budget time.Duration
+	// Observe is called with the number of retries of a call which was
+	// retried, and the error the call finally failed with, if it is not nil.
+	Observe func(operation string, retries int, err error)
+}
+
+// DefaultRetryPolicy is the retry policy of new clients.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	Budget:         30 * time.Second,
+}
+
+// transientCodes are the gRPC codes of transient errors of Tiller.
+var transientCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+// transientStatusPattern matches the errors of chart downloads which failed
+// with a transient HTTP status.
+var transientStatusPattern = regexp.MustCompile(`Failed to fetch .* : (5\d\d|429)\b`)
+
+// isTransientError returns true if a call to Tiller failed with a transient
+// error.
+func isTransientError(err error) bool {
+	e, ok := err.(*tillerError)
+	return ok && transientCodes[e.code]
+}
+
+// isTransientDownloadError returns true if a chart download failed with a
+// network error or a transient HTTP status.
+func isTransientDownloadError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return transientStatusPattern.MatchString(err.Error())
+}
+
+// isReleaseNotFound returns true if the error reports that the release does
+// not exist.
+func isReleaseNotFound(name string, err error) bool {
+	return err != nil && strings.Contains(err.Error(), driver.ErrReleaseNotFound(name).Error())
+}
+
+// retry calls fn until it succeeds or fails with an error which is not
+// retryable, with exponential backoff between the calls. The last error is
+// returned if the budget of the policy is exhausted or the context is done.
+func (p RetryPolicy) retry(ctx context.Context, operation string, fn func() error, retryable func(error) bool) error {
+	deadline := time.Now().Add(p.Budget)
+	backoff := p.InitialBackoff
+	retries := 0
+	for {
+		err := fn()
+		if err == nil || !retryable(err) {
+			p.observe(operation, retries, err)
+			return err
+		}
+
+		delay := backoff + time.Duration(rand.Float64()*p.Jitter*float64(backoff))
+		if time.Now().Add(delay).After(deadline) {
+			p.observe(operation, retries, err)
+			return err
+		}
+		glog.V(4).Infof("retrying %s in %v: %v", operation, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			p.observe(operation, retries, err)
+			return err
+		case <-timer.C:
+		}
+
+		retries++
+		backoff = time.Duration(float64(backoff) * p.Multiplier)
+		if backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// observe reports a call which was retried.
+func (p RetryPolicy) observe(operation string, retries int, err error) {
+	if retries > 0 && p.Observe != nil {
+		p.Observe(operation, retries, err)
+	}
+}
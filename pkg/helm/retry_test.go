@@ -0,0 +1,97 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestRetryPolicyRetry(t *testing.T) {
+	unavailable := &tillerError{code: codes.Unavailable, description: "connection refused"}
+	invalid := &tillerError{code: codes.InvalidArgument, description: "invalid chart"}
+	policy := RetryPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+		Multiplier:     2,
+		Budget:         time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		errs     []error
+		canceled bool
+		calls    int
+		retries  int
+		err      error
+	}{
+		{name: "success", policy: policy, errs: []error{nil}, calls: 1},
+		{name: "transient error", policy: policy, errs: []error{unavailable, unavailable, nil}, calls: 3, retries: 2},
+		{name: "permanent error", policy: policy, errs: []error{invalid}, calls: 1, err: invalid},
+		{name: "permanent error after retries", policy: policy, errs: []error{unavailable, invalid}, calls: 2, retries: 1, err: invalid},
+		{name: "no budget", policy: RetryPolicy{InitialBackoff: time.Millisecond}, errs: []error{unavailable}, calls: 1, err: unavailable},
+		{name: "canceled", policy: policy, errs: []error{unavailable}, canceled: true, calls: 1, err: unavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.canceled {
+				cancel()
+			}
+
+			observed := -1
+			var observedErr error
+			test.policy.Observe = func(operation string, retries int, err error) {
+				observed, observedErr = retries, err
+			}
+
+			calls := 0
+			err := test.policy.retry(ctx, "install", func() error {
+				err := test.errs[calls%len(test.errs)]
+				calls++
+				return err
+			}, isTransientError)
+
+			if err != test.err {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+			if calls != test.calls {
+				t.Errorf("expected %d calls, got %d", test.calls, calls)
+			}
+			if test.retries == 0 {
+				if observed != -1 {
+					t.Errorf("expected call without retries not to be observed, got %d retries", observed)
+				}
+				return
+			}
+			if observed != test.retries || observedErr != test.err {
+				t.Errorf("expected %d retries and error %v to be observed, got %d and %v", test.retries, test.err, observed, observedErr)
+			}
+		})
+	}
+}
+
+func TestIsTransientDownloadError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err: errors.New("Failed to fetch https://charts.example.com/redis-1.0.0.tgz : 503 Service Unavailable"), expected: true},
+		{err: errors.New("Failed to fetch https://charts.example.com/redis-1.0.0.tgz : 429 Too Many Requests"), expected: true},
+		{err: errors.New("Failed to fetch https://charts.example.com/redis-1.0.0.tgz : 404 Not Found"), expected: false},
+		{err: errors.New("chart redis not found"), expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			if actual := isTransientDownloadError(test.err); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// maxHistory is the number of revisions searched for the last deployed
+// revision of a release.
+const maxHistory = 256
+
+// RollbackRelease rolls a release back to a revision and returns the response.
+func (c *Client) RollbackRelease(ctx context.Context, name string, revision int32, opts ReleaseOptions) (*services.RollbackReleaseResponse, error) {
+	resp, err := c.backend.RollbackRelease(ctx, name, revision, opts)
+	if err != nil {
+		return nil, fmt.Errorf("rollback failed: %v", err)
+	}
+
+	return resp, nil
+}
+
+// LastDeployedRevision returns the latest revision of a release which was
+// deployed successfully.
+func (c *Client) LastDeployedRevision(ctx context.Context, name string) (int32, error) {
+	var resp *services.GetHistoryResponse
+	err := c.retryPolicy.retry(ctx, "history", func() (err error) {
+		resp, err = c.backend.ReleaseHistory(ctx, name, maxHistory)
+		return err
+	}, isTransientError)
+	if err != nil {
+		return 0, err
+	}
+
+	var revision int32
+	for _, r := range resp.GetReleases() {
+		switch r.GetInfo().GetStatus().GetCode() {
+		case release.Status_DEPLOYED, release.Status_SUPERSEDED:
+			if r.Version > revision {
+				revision = r.Version
+			}
+		}
+	}
+	if revision == 0 {
+		return 0, fmt.Errorf("release %s has no deployed revision", name)
+	}
+
+	return revision, nil
+}
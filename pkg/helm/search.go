@@ -7,9 +7,10 @@ import (
 	"k8s.io/helm/pkg/repo"
 )
 
-// SearchReleases searches releases from all repositories.
+// SearchReleases searches releases from all repositories, or from the bundle,
+// and from the chart sources.
 func (c *Client) SearchReleases() ([]*search.Result, error) {
-	index, err := buildIndex(c.settings.Home)
+	index, err := c.buildIndex()
 	if err != nil {
 		return nil, err
 	}
@@ -25,6 +26,46 @@ func (c *Client) SearchReleases() ([]*search.Result, error) {
 	return res, nil
 }
 
+// ChartVersion returns the latest version of a chart in the repositories.
+func (c *Client) ChartVersion(chart string) (string, error) {
+	index, err := c.buildIndex()
+	if err != nil {
+		return "", err
+	}
+
+	version, err := index.Chart(chart)
+	if err != nil {
+		return "", err
+	}
+
+	return version.Version, nil
+}
+
+// buildIndex builds the search index of the bundle of the client, or of the
+// repositories of the Helm home if the client has no bundle, and of the chart
+// sources of the client.
+func (c *Client) buildIndex() (*search.Index, error) {
+	var index *search.Index
+	if c.bundle != nil {
+		index = c.bundle.searchIndex()
+	} else {
+		var err error
+		if index, err = buildIndex(c.settings.Home); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, source := range c.sources {
+		ind, err := source.Index()
+		if err != nil {
+			glog.Warningf("chart source %q is unavailable: %v", source.Name(), err)
+			continue
+		}
+		index.AddRepo(source.Name(), ind, false)
+	}
+	return index, nil
+}
+
 func buildIndex(home helmpath.Home) (*search.Index, error) {
 	rf, err := repo.LoadRepositoriesFile(home.RepositoryFile())
 	if err != nil {
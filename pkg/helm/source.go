@@ -0,0 +1,41 @@
+package helm
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// ChartSource serves charts which are not in chart repositories. The charts of
+// a source are referenced as name/chart, as charts of repositories are.
+type ChartSource interface {
+	// Name returns the name the charts of the source are referenced with.
+	Name() string
+	// Index returns the index of the charts of the source.
+	Index() (*repo.IndexFile, error)
+	// Load loads a version of a chart of the source, or its latest version
+	// if the version is empty.
+	Load(ctx context.Context, name, version string) (*chart.Chart, error)
+}
+
+// AddChartSource adds a source the client searches and loads charts from.
+func (c *Client) AddChartSource(source ChartSource) {
+	c.sources = append(c.sources, source)
+}
+
+// chartSource returns the source of a chart and the name of the chart in the
+// source, or nil if the chart is not a chart of a source.
+func (c *Client) chartSource(name string) (ChartSource, string) {
+	parts := strings.SplitN(strings.TrimSpace(name), "/", 2)
+	if len(parts) != 2 {
+		return nil, ""
+	}
+	for _, source := range c.sources {
+		if source.Name() == parts[0] {
+			return source, parts[1]
+		}
+	}
+	return nil, ""
+}
@@ -1,18 +1,20 @@
 package helm
 
 import (
-	"k8s.io/helm/pkg/helm"
+	"context"
+
 	"k8s.io/helm/pkg/proto/hapi/services"
 )
 
 // ReleaseStatus returns the given release's status.
-func (c *Client) ReleaseStatus(name string) (*services.GetReleaseStatusResponse, error) {
-	resp, err := c.client.ReleaseStatus(
-		name,
-		helm.StatusReleaseVersion(0),
-	)
+func (c *Client) ReleaseStatus(ctx context.Context, name string) (*services.GetReleaseStatusResponse, error) {
+	var resp *services.GetReleaseStatusResponse
+	err := c.retryPolicy.retry(ctx, "status", func() (err error) {
+		resp, err = c.backend.ReleaseStatus(ctx, name)
+		return err
+	}, isTransientError)
 	if err != nil {
-		return nil, prettyError(err)
+		return nil, err
 	}
 
 	return resp, nil
@@ -0,0 +1,324 @@
+package helm
+
+import (
+	"context"
+	"crypto/tls"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/helm/pkg/version"
+)
+
+const (
+	// tillerConnectTimeout is the time to wait for a connection to Tiller.
+	tillerConnectTimeout = 5 * time.Second
+	// tillerMaxMsgSize is the size limit of messages received from Tiller.
+	tillerMaxMsgSize = 1024 * 1024 * 20
+)
+
+// TillerLocator returns the host and port of Tiller.
+type TillerLocator func() (string, error)
+
+// tillerBackend is a Backend which manages releases through the gRPC API of
+// Tiller. The calls are bound to the context they are made with, so that they
+// are cancelled with the context.
+type tillerBackend struct {
+	// locate returns the host and port of Tiller.
+	locate TillerLocator
+	// tlsOptions holds the TLS settings of the connection to Tiller, or nil
+	// if the connection is not secured.
+	tlsOptions *TLSOptions
+
+	mutex sync.Mutex
+	// host and port of Tiller connections are made to.
+	host string
+	// tlsConfig is the TLS config loaded from the TLS files.
+	tlsConfig *tls.Config
+	// modTimes holds the modification times of the TLS files the TLS config
+	// was loaded from.
+	modTimes []time.Time
+	// stale is set if Tiller could not be reached, so that it is located
+	// again.
+	stale bool
+}
+
+// NewTillerBackend creates a Backend which connects to Tiller at the host.
+func NewTillerBackend(host string) Backend {
+	return &tillerBackend{
+		locate: func() (string, error) { return host, nil },
+	}
+}
+
+// NewLocatedTillerBackend creates a Backend which connects to the Tiller
+// returned by the locator, with mutual TLS if the TLS options are not nil.
+// Tiller is located on first use, and again when it cannot be reached.
+func NewLocatedTillerBackend(locate TillerLocator, tlsOptions *TLSOptions) Backend {
+	return &tillerBackend{
+		locate:     locate,
+		tlsOptions: tlsOptions,
+	}
+}
+
+// target returns the host of Tiller and the TLS config of the connection. Tiller
+// is located again if it could not be reached, and the TLS files are reloaded
+// if they have changed since they were loaded.
+func (t *tillerBackend) target() (string, *tls.Config, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.host == "" || t.stale {
+		host, err := t.locate()
+		if err != nil {
+			if t.host == "" {
+				return "", nil, err
+			}
+			glog.Warningf("failed to locate Tiller: %v", err)
+		} else if host != t.host {
+			glog.Infof("connecting to Tiller at %s", host)
+			t.host = host
+			t.tlsConfig = nil
+		}
+		t.stale = false
+	}
+
+	if t.tlsOptions != nil && (t.tlsConfig == nil || !reflect.DeepEqual(t.modTimes, t.tlsOptions.modTimes())) {
+		modTimes := t.tlsOptions.modTimes()
+		cfg, err := t.tlsOptions.config(t.host)
+		if err != nil {
+			if t.tlsConfig == nil {
+				return "", nil, err
+			}
+			// Keep the current certificates, the files may be updated
+			// partially.
+			glog.Warningf("failed to reload TLS files: %v", err)
+		} else {
+			if t.tlsConfig != nil {
+				glog.Infof("reloaded TLS files for Tiller at %s", t.host)
+			}
+			t.tlsConfig = cfg
+			t.modTimes = modTimes
+		}
+	}
+	return t.host, t.tlsConfig, nil
+}
+
+// connect opens a connection to Tiller. The connection is closed by the
+// caller once the call is done.
+func (t *tillerBackend) connect(ctx context.Context) (*grpc.ClientConn, error) {
+	host, tlsConfig, err := t.target()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			// Send keepalives so that long running calls are not closed
+			// by proxies.
+			Time: 30 * time.Second,
+		}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(tillerMaxMsgSize)),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, tillerConnectTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, host, opts...)
+	if err != nil {
+		return nil, t.check(ctx, err)
+	}
+	return conn, nil
+}
+
+// call runs a call of the release service of Tiller with the context. The
+// context carries the version of the client, which Tiller checks for
+// compatibility.
+func (t *tillerBackend) call(ctx context.Context, fn func(context.Context, services.ReleaseServiceClient) error) error {
+	conn, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("x-helm-api-client", version.GetVersion()))
+	return t.check(ctx, fn(ctx, services.NewReleaseServiceClient(conn)))
+}
+
+// check marks Tiller to be located again if it could not be reached, and
+// returns the error in a user-friendly form. Errors caused by the context are
+// returned as the error of the context.
+func (t *tillerBackend) check(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Connecting to Tiller fails with context.DeadlineExceeded.
+	if err == context.DeadlineExceeded {
+		return t.unreachable(&tillerError{code: codes.Unavailable, description: err.Error()})
+	}
+	if code := grpc.Code(err); code == codes.Unavailable || code == codes.DeadlineExceeded {
+		return t.unreachable(prettyError(err))
+	}
+	return prettyError(err)
+}
+
+// unreachable marks Tiller to be located again and returns the error.
+func (t *tillerBackend) unreachable(err error) error {
+	t.mutex.Lock()
+	t.stale = true
+	t.mutex.Unlock()
+	return err
+}
+
+// InstallRelease injects the global registry of the registry rewrites as the
+// global.imageRegistry value, since the manifests are rendered by Tiller, and
+// records it in the chart. Other rules cannot be applied by Tiller.
+func (t *tillerBackend) InstallRelease(ctx context.Context, ch *chart.Chart, namespace string, name string, rawValues []byte, opts ReleaseOptions) (*services.InstallReleaseResponse, error) {
+	registry := opts.RegistryRewrites.GlobalRegistry()
+	rawValues, err := injectRegistryValues(rawValues, registry)
+	if err != nil {
+		return nil, err
+	}
+	ch = annotateChart(ch, ImageRegistryAnnotation, registry)
+	req := &services.InstallReleaseRequest{
+		Chart:     ch,
+		Values:    &chart.Config{Raw: string(rawValues)},
+		DryRun:    opts.DryRun,
+		Name:      name,
+		Namespace: namespace,
+		Timeout:   opts.Timeout,
+		Wait:      opts.Wait,
+	}
+	if err := processRequirements(req.Chart, req.Values); err != nil {
+		return nil, err
+	}
+
+	var resp *services.InstallReleaseResponse
+	err = t.call(ctx, func(ctx context.Context, client services.ReleaseServiceClient) (err error) {
+		resp, err = client.InstallRelease(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// UpdateRelease injects the global registry of the registry rewrites as the
+// global.imageRegistry value, since the manifests are rendered by Tiller, and
+// records it in the chart. Other rules cannot be applied by Tiller.
+func (t *tillerBackend) UpdateRelease(ctx context.Context, ch *chart.Chart, name string, rawValues []byte, opts ReleaseOptions) (*services.UpdateReleaseResponse, error) {
+	registry := opts.RegistryRewrites.GlobalRegistry()
+	rawValues, err := injectRegistryValues(rawValues, registry)
+	if err != nil {
+		return nil, err
+	}
+	ch = annotateChart(ch, ImageRegistryAnnotation, registry)
+	req := &services.UpdateReleaseRequest{
+		Name:        name,
+		Chart:       ch,
+		Values:      &chart.Config{Raw: string(rawValues)},
+		DryRun:      opts.DryRun,
+		Timeout:     opts.Timeout,
+		ResetValues: true,
+		Wait:        opts.Wait,
+	}
+	if err := processRequirements(req.Chart, req.Values); err != nil {
+		return nil, err
+	}
+
+	var resp *services.UpdateReleaseResponse
+	err = t.call(ctx, func(ctx context.Context, client services.ReleaseServiceClient) (err error) {
+		resp, err = client.UpdateRelease(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (t *tillerBackend) DeleteRelease(ctx context.Context, name string, opts ReleaseOptions) (*services.UninstallReleaseResponse, error) {
+	req := &services.UninstallReleaseRequest{
+		Name:    name,
+		Purge:   true,
+		Timeout: opts.Timeout,
+	}
+
+	var resp *services.UninstallReleaseResponse
+	err := t.call(ctx, func(ctx context.Context, client services.ReleaseServiceClient) (err error) {
+		resp, err = client.UninstallRelease(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (t *tillerBackend) RollbackRelease(ctx context.Context, name string, revision int32, opts ReleaseOptions) (*services.RollbackReleaseResponse, error) {
+	req := &services.RollbackReleaseRequest{
+		Name:    name,
+		Version: revision,
+		Timeout: opts.Timeout,
+		Wait:    opts.Wait,
+	}
+
+	var resp *services.RollbackReleaseResponse
+	err := t.call(ctx, func(ctx context.Context, client services.ReleaseServiceClient) (err error) {
+		resp, err = client.RollbackRelease(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (t *tillerBackend) ReleaseStatus(ctx context.Context, name string) (*services.GetReleaseStatusResponse, error) {
+	req := &services.GetReleaseStatusRequest{Name: name}
+
+	var resp *services.GetReleaseStatusResponse
+	err := t.call(ctx, func(ctx context.Context, client services.ReleaseServiceClient) (err error) {
+		resp, err = client.GetReleaseStatus(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (t *tillerBackend) ReleaseContent(ctx context.Context, name string) (*services.GetReleaseContentResponse, error) {
+	req := &services.GetReleaseContentRequest{Name: name}
+
+	var resp *services.GetReleaseContentResponse
+	err := t.call(ctx, func(ctx context.Context, client services.ReleaseServiceClient) (err error) {
+		resp, err = client.GetReleaseContent(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (t *tillerBackend) ReleaseHistory(ctx context.Context, name string, max int32) (*services.GetHistoryResponse, error) {
+	req := &services.GetHistoryRequest{Name: name, Max: max}
+
+	var resp *services.GetHistoryResponse
+	err := t.call(ctx, func(ctx context.Context, client services.ReleaseServiceClient) (err error) {
+		resp, err = client.GetHistory(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// processRequirements enables and disables the dependencies of a chart by
+// their conditions and tags, and imports the values of dependencies, as the
+// Helm client does before sending a chart to Tiller.
+func processRequirements(ch *chart.Chart, values *chart.Config) error {
+	if err := chartutil.ProcessRequirementsEnabled(ch, values); err != nil {
+		return err
+	}
+	return chartutil.ProcessRequirementsImportValues(ch)
+}
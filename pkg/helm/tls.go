@@ -0,0 +1,79 @@
+package helm
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"k8s.io/helm/pkg/tlsutil"
+)
+
+// TLSOptions holds the settings of a mutual TLS connection to Tiller. The
+// files are typically mounted from a Secret, and are reloaded when they
+// change.
+type TLSOptions struct {
+	// CACertFile is the path of the CA certificate verifying Tiller.
+	CACertFile string
+	// CertFile is the path of the client certificate.
+	CertFile string
+	// KeyFile is the path of the key of the client certificate.
+	KeyFile string
+	// ServerName is the name the certificate of Tiller is verified against.
+	// The host of Tiller is used if it is empty.
+	ServerName string
+	// Verify the certificate of Tiller. The certificate is always verified
+	// if a CA certificate is configured.
+	Verify bool
+}
+
+// Enabled returns true if a client certificate is configured.
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" && o.KeyFile != ""
+}
+
+// verify returns true if the certificate of Tiller is verified.
+func (o TLSOptions) verify() bool {
+	return o.Verify || o.CACertFile != ""
+}
+
+// config loads the TLS config for connecting to Tiller at the host.
+func (o TLSOptions) config(host string) (*tls.Config, error) {
+	cfg, err := tlsutil.ClientConfig(tlsutil.Options{
+		CaCertFile:         o.CACertFile,
+		CertFile:           o.CertFile,
+		KeyFile:            o.KeyFile,
+		InsecureSkipVerify: !o.verify(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS config for Tiller: %v", err)
+	}
+
+	cfg.ServerName = o.ServerName
+	if cfg.ServerName == "" && o.verify() {
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			cfg.ServerName = name
+		} else {
+			cfg.ServerName = host
+		}
+	}
+	return cfg, nil
+}
+
+// modTimes returns the modification times of the files, which change when the
+// files are updated.
+func (o TLSOptions) modTimes() []time.Time {
+	var times []time.Time
+	for _, file := range []string{o.CACertFile, o.CertFile, o.KeyFile} {
+		if file == "" {
+			continue
+		}
+		var modTime time.Time
+		if info, err := os.Stat(file); err == nil {
+			modTime = info.ModTime()
+		}
+		times = append(times, modTime)
+	}
+	return times
+}
@@ -0,0 +1,109 @@
+package helm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSOptionsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiller-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	tests := []struct {
+		name       string
+		opts       TLSOptions
+		insecure   bool
+		roots      bool
+		serverName string
+	}{
+		{
+			name:     "no verification",
+			opts:     TLSOptions{CertFile: certFile, KeyFile: keyFile},
+			insecure: true,
+		},
+		{
+			name:       "system CAs",
+			opts:       TLSOptions{CertFile: certFile, KeyFile: keyFile, Verify: true},
+			serverName: "tiller.kube-system",
+		},
+		{
+			name:       "CA certificate without verify flag",
+			opts:       TLSOptions{CACertFile: certFile, CertFile: certFile, KeyFile: keyFile},
+			roots:      true,
+			serverName: "tiller.kube-system",
+		},
+		{
+			name:       "server name",
+			opts:       TLSOptions{CACertFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "tiller"},
+			roots:      true,
+			serverName: "tiller",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := test.opts.config("tiller.kube-system:44134")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.InsecureSkipVerify != test.insecure {
+				t.Errorf("expected InsecureSkipVerify %v, got %v", test.insecure, cfg.InsecureSkipVerify)
+			}
+			if (cfg.RootCAs != nil) != test.roots {
+				t.Errorf("expected root CAs to be loaded: %v", test.roots)
+			}
+			if cfg.ServerName != test.serverName {
+				t.Errorf("expected server name %q, got %q", test.serverName, cfg.ServerName)
+			}
+		})
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate and its key into the
+// directory.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tiller"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
@@ -1,41 +1,73 @@
 package helm
 
 import (
+	"context"
 	"fmt"
-	"strings"
 
 	"github.com/ghodss/yaml"
-	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/proto/hapi/services"
-	"k8s.io/helm/pkg/storage/driver"
 )
 
 // UpdateRelease loads a chart from chstr and updates a release to a new/different chart.
-func (c *Client) UpdateRelease(chart string, name string, values map[string]interface{}) (*services.UpdateReleaseResponse, error) {
+// The values replace the values of the current revision of the release.
+func (c *Client) UpdateRelease(ctx context.Context, chart string, name string, values map[string]interface{}, opts ReleaseOptions) (*services.UpdateReleaseResponse, error) {
 	rawValues, err := yaml.Marshal(values)
 	if err != nil {
 		return nil, err
 	}
 
-	chartPath, err := locateChartPath("", "", "", chart, "", false, "", "", "", "", c.settings)
+	if opts.RegistryRewrites == nil {
+		opts.RegistryRewrites = c.registryRewrites
+	}
+
+	chartRequested, err := c.loadChart(ctx, chart, opts.ChartVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = c.client.ReleaseHistory(name, helm.WithMaxHistory(1))
-	if err != nil && strings.Contains(err.Error(), driver.ErrReleaseNotFound(name).Error()) {
+	// Upgrades of releases which do not exist, or whose history cannot be
+	// read, fail before the chart is sent to the backend.
+	revision, err := c.latestRevision(ctx, name)
+	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.client.UpdateRelease(
-		name,
-		chartPath,
-		helm.UpdateValueOverrides(rawValues),
-		helm.UpgradeTimeout(300),
-	)
+	// The upgrade may have reached Tiller before it failed, so it is only
+	// retried if no new revision of the release was created.
+	var resp *services.UpdateReleaseResponse
+	err = c.retryPolicy.retry(ctx, "upgrade", func() (err error) {
+		resp, err = c.backend.UpdateRelease(ctx, chartRequested, name, rawValues, opts)
+		return err
+	}, func(err error) bool {
+		if !isTransientError(err) || revision == 0 {
+			return false
+		}
+		latest, err := c.latestRevision(ctx, name)
+		return err == nil && latest == revision
+	})
 	if err != nil {
-		return nil, fmt.Errorf("upgrade failed: %v", prettyError(err))
+		return nil, fmt.Errorf("upgrade failed: %v", err)
 	}
 
 	return resp, nil
 }
+
+// latestRevision returns the latest revision of a release.
+func (c *Client) latestRevision(ctx context.Context, name string) (int32, error) {
+	var resp *services.GetHistoryResponse
+	err := c.retryPolicy.retry(ctx, "history", func() (err error) {
+		resp, err = c.backend.ReleaseHistory(ctx, name, 1)
+		return err
+	}, isTransientError)
+	if err != nil {
+		return 0, err
+	}
+
+	var revision int32
+	for _, r := range resp.GetReleases() {
+		if r.GetVersion() > revision {
+			revision = r.GetVersion()
+		}
+	}
+	return revision, nil
+}
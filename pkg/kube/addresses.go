@@ -0,0 +1,168 @@
+package kube
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+// Addresses holds the external addresses of the resources of a release.
+type Addresses struct {
+	// Ingresses holds the hosts served by the Ingresses of the release.
+	Ingresses []IngressAddress
+	// LoadBalancers holds the addresses of the LoadBalancer Services of the
+	// release.
+	LoadBalancers []LoadBalancerAddress
+}
+
+// IngressAddress is a host and path served by an Ingress.
+type IngressAddress struct {
+	// Name of the Ingress.
+	Name string
+	// Host served by the Ingress.
+	Host string
+	// Path served by the Ingress.
+	Path string
+	// TLS is true if the host is served over TLS.
+	TLS bool
+}
+
+// URL returns the URL of the Ingress address.
+func (a IngressAddress) URL() string {
+	u := url.URL{Scheme: "http", Host: a.Host, Path: a.Path}
+	if a.TLS {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+// LoadBalancerAddress is the address of a LoadBalancer Service.
+type LoadBalancerAddress struct {
+	// Name of the Service.
+	Name string
+	// Address is the IP or hostname of the load balancer.
+	Address string
+	// Ports exposed by the Service.
+	Ports []int32
+}
+
+// URL returns the URL of the first port of the load balancer.
+func (a LoadBalancerAddress) URL() string {
+	host := a.Address
+	if len(a.Ports) > 0 {
+		switch a.Ports[0] {
+		case 80:
+		case 443:
+			return "https://" + host
+		default:
+			host = host + ":" + strconv.Itoa(int(a.Ports[0]))
+		}
+	}
+	return "http://" + host
+}
+
+// URL returns the URL of the first Ingress address, or the first load
+// balancer address if there are no Ingresses. An empty string is returned if
+// none of the resources has an address.
+func (a *Addresses) URL() string {
+	if len(a.Ingresses) > 0 {
+		return a.Ingresses[0].URL()
+	}
+	if len(a.LoadBalancers) > 0 {
+		return a.LoadBalancers[0].URL()
+	}
+	return ""
+}
+
+// GetAddresses returns the addresses of the Ingresses and LoadBalancer Services
+// of a release. Resources which are not created yet and load balancers which
+// are not assigned an address yet are omitted.
+func GetAddresses(client kubeclientset.Interface, namespace string, resources []Resource) (*Addresses, error) {
+	addresses := &Addresses{}
+	for _, resource := range resources {
+		ns := resource.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+
+		switch resource.Kind {
+		case "Ingress":
+			ingress, err := client.ExtensionsV1beta1().Ingresses(ns).Get(resource.Name(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get ingress %s: %v", resource.Name(), err)
+			}
+			tlsHosts := map[string]bool{}
+			for _, tls := range ingress.Spec.TLS {
+				for _, host := range tls.Hosts {
+					tlsHosts[host] = true
+				}
+			}
+			for _, rule := range ingress.Spec.Rules {
+				host := rule.Host
+				if host == "" {
+					host = loadBalancerAddress(ingress.Status.LoadBalancer)
+				}
+				if host == "" {
+					continue
+				}
+				path := ""
+				if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 {
+					path = rule.HTTP.Paths[0].Path
+				}
+				addresses.Ingresses = append(addresses.Ingresses, IngressAddress{
+					Name: ingress.Name,
+					Host: host,
+					Path: path,
+					TLS:  tlsHosts[rule.Host],
+				})
+			}
+		case "Service":
+			service, err := client.CoreV1().Services(ns).Get(resource.Name(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get service %s: %v", resource.Name(), err)
+			}
+			if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+				continue
+			}
+			address := loadBalancerAddress(service.Status.LoadBalancer)
+			if address == "" {
+				continue
+			}
+			var ports []int32
+			for _, port := range service.Spec.Ports {
+				ports = append(ports, port.Port)
+			}
+			addresses.LoadBalancers = append(addresses.LoadBalancers, LoadBalancerAddress{
+				Name:    service.Name,
+				Address: address,
+				Ports:   ports,
+			})
+		}
+	}
+
+	return addresses, nil
+}
+
+// loadBalancerAddress returns the first IP or hostname of a load balancer.
+func loadBalancerAddress(status corev1.LoadBalancerStatus) string {
+	for _, ingress := range status.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package kube
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+// ServiceAddress is the in-cluster address of a Service.
+type ServiceAddress struct {
+	// Name of the Service.
+	Name string
+	// Host is the cluster DNS name of the Service.
+	Host string
+	// Port is the first port of the Service.
+	Port int32
+	// PortName is the name of the first port of the Service.
+	PortName string
+	// Ports holds the ports of the Service keyed by port name.
+	Ports map[string]int32
+	// Headless is true if the Service has no cluster IP.
+	Headless bool
+}
+
+// GetServiceAddresses returns the in-cluster addresses of the Services of a
+// release, in the order of the manifest.
+func GetServiceAddresses(client kubeclientset.Interface, namespace string, resources []Resource) ([]ServiceAddress, error) {
+	var addresses []ServiceAddress
+	for _, resource := range resources {
+		if resource.Kind != "Service" {
+			continue
+		}
+		ns := resource.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+
+		service, err := client.CoreV1().Services(ns).Get(resource.Name(), metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get service %s: %v", resource.Name(), err)
+		}
+
+		address := ServiceAddress{
+			Name:     service.Name,
+			Host:     fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace),
+			Ports:    map[string]int32{},
+			Headless: service.Spec.ClusterIP == corev1.ClusterIPNone,
+		}
+		for i, port := range service.Spec.Ports {
+			if i == 0 {
+				address.Port = port.Port
+				address.PortName = port.Name
+			}
+			address.Ports[port.Name] = port.Port
+		}
+		addresses = append(addresses, address)
+	}
+
+	return addresses, nil
+}
+
+// GetSecretData returns the data of the Secrets of a release keyed by Secret
+// name.
+func GetSecretData(client kubeclientset.Interface, namespace string, resources []Resource) (map[string]map[string]string, error) {
+	data := map[string]map[string]string{}
+	for _, resource := range resources {
+		if resource.Kind != "Secret" {
+			continue
+		}
+		ns := resource.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+
+		values, err := GetSecret(client, ns, resource.Name())
+		if err != nil {
+			return nil, err
+		}
+		data[resource.Name()] = values
+	}
+
+	return data, nil
+}
@@ -0,0 +1,220 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// redactedValue replaces the values of Secrets in diffs.
+	redactedValue = "<redacted>"
+	// changedValue replaces the values of Secrets in diffs which changed.
+	changedValue = "<redacted, changed>"
+)
+
+// secretFields are the fields of Secrets holding values which are redacted.
+var secretFields = []string{"data", "stringData"}
+
+// manifestDocument is a resource of a release manifest with its source.
+type manifestDocument struct {
+	key  string
+	text string
+	// secret is the parsed Secret of the document, whose text is redacted.
+	secret map[string]interface{}
+}
+
+// DiffManifests returns a line diff of the resources which differ between two
+// release manifests. Resources are matched by kind, namespace and name, and
+// the lines of each changed resource are prefixed with "-" if they are only in
+// the current manifest, with "+" if they are only in the updated manifest, and
+// with " " otherwise. An empty diff means that the manifests render the same
+// resources. The values of Secrets are redacted, and only the keys whose
+// values changed are marked.
+func DiffManifests(current string, updated string) (string, error) {
+	currentDocs, err := splitManifest(current)
+	if err != nil {
+		return "", err
+	}
+	updatedDocs, err := splitManifest(updated)
+	if err != nil {
+		return "", err
+	}
+
+	updatedIndex := make(map[string]manifestDocument, len(updatedDocs))
+	for _, doc := range updatedDocs {
+		updatedIndex[doc.key] = doc
+	}
+
+	var buf bytes.Buffer
+	seen := make(map[string]bool, len(currentDocs))
+	for _, doc := range currentDocs {
+		seen[doc.key] = true
+		updatedDoc, ok := updatedIndex[doc.key]
+		if !ok {
+			writeDiff(&buf, doc.key, "removed", doc.text, "")
+			continue
+		}
+		text := updatedDoc.text
+		if doc.secret != nil && updatedDoc.secret != nil {
+			text, err = redactSecret(updatedDoc.secret, changedSecretKeys(doc.secret, updatedDoc.secret))
+			if err != nil {
+				return "", err
+			}
+		}
+		if text != doc.text {
+			writeDiff(&buf, doc.key, "changed", doc.text, text)
+		}
+	}
+	for _, doc := range updatedDocs {
+		if !seen[doc.key] {
+			writeDiff(&buf, doc.key, "added", "", doc.text)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// splitManifest returns the resource documents of a release manifest.
+func splitManifest(manifest string) ([]manifestDocument, error) {
+	var docs []manifestDocument
+	for _, doc := range separator.Split(manifest, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+
+		var resource Resource
+		if err := yaml.Unmarshal([]byte(doc), &resource); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %v", err)
+		}
+		if resource.Kind == "" {
+			continue
+		}
+		document := manifestDocument{
+			key:  fmt.Sprintf("%s/%s/%s", resource.Kind, resource.Metadata.Namespace, resource.Name()),
+			text: strings.Trim(doc, "\n"),
+		}
+		if resource.Kind == "Secret" {
+			if err := yaml.Unmarshal([]byte(doc), &document.secret); err != nil {
+				return nil, fmt.Errorf("failed to parse manifest: %v", err)
+			}
+			text, err := redactSecret(document.secret, nil)
+			if err != nil {
+				return nil, err
+			}
+			document.text = text
+		}
+		docs = append(docs, document)
+	}
+	return docs, nil
+}
+
+// redactSecret returns the text of a Secret with its values redacted. The
+// values of the changed keys, given as field/key, are marked as changed.
+func redactSecret(secret map[string]interface{}, changed map[string]bool) (string, error) {
+	redacted := make(map[string]interface{}, len(secret))
+	for k, v := range secret {
+		redacted[k] = v
+	}
+	for _, field := range secretFields {
+		values, ok := secret[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		redactedValues := make(map[string]interface{}, len(values))
+		for k := range values {
+			redactedValues[k] = redactedValue
+			if changed[field+"/"+k] {
+				redactedValues[k] = changedValue
+			}
+		}
+		redacted[field] = redactedValues
+	}
+
+	text, err := yaml.Marshal(redacted)
+	if err != nil {
+		return "", fmt.Errorf("failed to redact secret: %v", err)
+	}
+	return strings.Trim(string(text), "\n"), nil
+}
+
+// changedSecretKeys returns the keys, as field/key, of the values of a Secret
+// which differ in the updated Secret.
+func changedSecretKeys(current map[string]interface{}, updated map[string]interface{}) map[string]bool {
+	changed := map[string]bool{}
+	for _, field := range secretFields {
+		currentValues, _ := current[field].(map[string]interface{})
+		updatedValues, _ := updated[field].(map[string]interface{})
+		for k, v := range updatedValues {
+			if currentValue, ok := currentValues[k]; ok && !reflect.DeepEqual(currentValue, v) {
+				changed[field+"/"+k] = true
+			}
+		}
+	}
+	return changed
+}
+
+// writeDiff writes the line diff of a resource.
+func writeDiff(buf *bytes.Buffer, key string, change string, from string, to string) {
+	fmt.Fprintf(buf, "=== %s (%s)\n", key, change)
+	for _, line := range diffLines(splitLines(from), splitLines(to)) {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffLines returns the lines of a diff from the longest common subsequence of
+// two texts.
+func diffLines(from []string, to []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of from[i:]
+	// and to[j:].
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			lines = append(lines, " "+from[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "-"+from[i])
+			i++
+		default:
+			lines = append(lines, "+"+to[j])
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		lines = append(lines, "-"+from[i])
+	}
+	for ; j < len(to); j++ {
+		lines = append(lines, "+"+to[j])
+	}
+	return lines
+}
@@ -0,0 +1,190 @@
+package kube
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to []string
+		expected []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "equal",
+			from:     []string{"a", "b"},
+			to:       []string{"a", "b"},
+			expected: []string{" a", " b"},
+		},
+		{
+			name:     "added",
+			to:       []string{"a", "b"},
+			expected: []string{"+a", "+b"},
+		},
+		{
+			name:     "removed",
+			from:     []string{"a", "b"},
+			expected: []string{"-a", "-b"},
+		},
+		{
+			name:     "changed",
+			from:     []string{"a", "b", "c"},
+			to:       []string{"a", "x", "c"},
+			expected: []string{" a", "-b", "+x", " c"},
+		},
+		{
+			name:     "inserted",
+			from:     []string{"a", "c"},
+			to:       []string{"a", "b", "c", "d"},
+			expected: []string{" a", "+b", " c", "+d"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := diffLines(test.from, test.to)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	const configMap = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  mode: %s`
+	const secret = `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+data:
+  password: %s
+  username: YWRtaW4=`
+
+	tests := []struct {
+		name     string
+		current  string
+		updated  string
+		expected string
+		hidden   []string
+	}{
+		{
+			name:    "unchanged",
+			current: strings.Replace(configMap, "%s", "a", 1),
+			updated: strings.Replace(configMap, "%s", "a", 1),
+		},
+		{
+			name:    "changed",
+			current: strings.Replace(configMap, "%s", "a", 1),
+			updated: strings.Replace(configMap, "%s", "b", 1),
+			expected: `=== ConfigMap//config (changed)
+ apiVersion: v1
+ kind: ConfigMap
+ metadata:
+   name: config
+ data:
+-  mode: a
++  mode: b
+`,
+		},
+		{
+			name:    "added and removed",
+			current: strings.Replace(configMap, "%s", "a", 1),
+			updated: `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: service`,
+			expected: `=== ConfigMap//config (removed)
+-apiVersion: v1
+-kind: ConfigMap
+-metadata:
+-  name: config
+-data:
+-  mode: a
+=== Service//service (added)
++apiVersion: v1
++kind: Service
++metadata:
++  name: service
+`,
+		},
+		{
+			name:    "secret unchanged",
+			current: strings.Replace(secret, "%s", "c2VjcmV0", 1),
+			updated: strings.Replace(secret, "%s", "c2VjcmV0", 1),
+		},
+		{
+			name:    "secret changed",
+			current: strings.Replace(secret, "%s", "c2VjcmV0", 1),
+			updated: strings.Replace(secret, "%s", "bmV3LXNlY3JldA==", 1),
+			expected: `=== Secret//credentials (changed)
+ apiVersion: v1
+ data:
+-  password: <redacted>
++  password: <redacted, changed>
+   username: <redacted>
+ kind: Secret
+ metadata:
+   name: credentials
+`,
+			hidden: []string{"c2VjcmV0", "bmV3LXNlY3JldA==", "YWRtaW4="},
+		},
+		{
+			name:    "secret added",
+			updated: strings.Replace(secret, "%s", "c2VjcmV0", 1),
+			expected: `=== Secret//credentials (added)
++apiVersion: v1
++data:
++  password: <redacted>
++  username: <redacted>
++kind: Secret
++metadata:
++  name: credentials
+`,
+			hidden: []string{"c2VjcmV0", "YWRtaW4="},
+		},
+		{
+			name:    "secret string data",
+			current: "kind: Secret\nmetadata:\n  name: credentials\nstringData:\n  password: secret",
+			updated: "kind: Secret\nmetadata:\n  name: credentials\nstringData:\n  password: secret\n  token: token",
+			expected: `=== Secret//credentials (changed)
+ kind: Secret
+ metadata:
+   name: credentials
+ stringData:
+   password: <redacted>
++  token: <redacted>
+`,
+			hidden: []string{"secret\n", "token: token"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := DiffManifests(test.current, test.updated)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected diff\n%s\ngot\n%s", test.expected, actual)
+			}
+			for _, value := range test.hidden {
+				if strings.Contains(actual, value) {
+					t.Errorf("diff contains secret value %q", value)
+				}
+			}
+		})
+	}
+}
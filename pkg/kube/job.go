@@ -0,0 +1,150 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeclientset "k8s.io/client-go/kubernetes"
+	batchclient "k8s.io/client-go/kubernetes/typed/batch/v1"
+)
+
+// jobPollInterval is the interval between checks of the status of a Job.
+const jobPollInterval = 2 * time.Second
+
+// RunJob creates a Job, waits until it completes or fails within the timeout,
+// and deletes it afterwards. The wait is abandoned when the context is done.
+// A Job of the same name which carries the same value of the given label is
+// adopted if it is still running or completed, e.g. when the broker restarted
+// while the Job was running. Failed and terminating Jobs are replaced.
+func RunJob(ctx context.Context, client kubeclientset.Interface, job *batchv1.Job, label string, timeout time.Duration) error {
+	jobs := client.BatchV1().Jobs(job.Namespace)
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := createJob(waitCtx, jobs, job, label)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("job %s was abandoned: %v", job.Name, ctx.Err())
+	} else if err == context.DeadlineExceeded {
+		return fmt.Errorf("existing job %s was not deleted within %v", job.Name, timeout)
+	} else if err != nil {
+		return fmt.Errorf("failed to create job %s: %v", job.Name, err)
+	}
+	defer func() {
+		propagation := metav1.DeletePropagationBackground
+		err := jobs.Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !apierrors.IsNotFound(err) {
+			glog.Warningf("failed to delete job %s: %v", job.Name, err)
+		}
+	}()
+
+	var failed bool
+	var failure string
+	err = wait.PollUntil(jobPollInterval, func() (bool, error) {
+		current, err := jobs.Get(job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		var done bool
+		done, failed, failure = jobFinished(current)
+		return done, nil
+	}, waitCtx.Done())
+	if err == wait.ErrWaitTimeout && ctx.Err() != nil {
+		return fmt.Errorf("job %s was abandoned: %v", job.Name, ctx.Err())
+	} else if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("job %s did not complete within %v", job.Name, timeout)
+	} else if err != nil {
+		return fmt.Errorf("failed to get job %s: %v", job.Name, err)
+	}
+	if failed {
+		return fmt.Errorf("job %s failed: %s", job.Name, failure)
+	}
+
+	return nil
+}
+
+// createJob creates a Job, or adopts a running or completed Job of the same
+// name and label value. Failed Jobs are deleted, and the Job is created once
+// the existing Job is gone. The context bounds the wait for the deletion.
+func createJob(ctx context.Context, jobs batchclient.JobInterface, job *batchv1.Job, label string) error {
+	for {
+		_, err := jobs.Create(job)
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+
+		existing, err := jobs.Get(job.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if existing.Labels[label] != job.Labels[label] {
+			return fmt.Errorf("job %s exists and is not labelled %s=%s", job.Name, label, job.Labels[label])
+		}
+		if existing.DeletionTimestamp == nil {
+			if _, failed, _ := jobFinished(existing); !failed {
+				return nil
+			}
+			propagation := metav1.DeletePropagationBackground
+			err := jobs.Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// jobFinished returns whether a Job completed or failed, and the message of
+// its failure.
+func jobFinished(job *batchv1.Job) (bool, bool, string) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, false, ""
+		case batchv1.JobFailed:
+			return true, true, condition.Message
+		}
+	}
+	return false, false, ""
+}
+
+// GetSecret returns the data of a Secret.
+func GetSecret(client kubeclientset.Interface, namespace string, name string) (map[string]string, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %v", name, err)
+	}
+
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	return data, nil
+}
+
+// DeleteSecret deletes a Secret. Secrets which do not exist are ignored.
+func DeleteSecret(client kubeclientset.Interface, namespace string, name string) error {
+	err := client.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s: %v", name, err)
+	}
+	return nil
+}
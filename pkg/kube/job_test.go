@@ -0,0 +1,72 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreateJob(t *testing.T) {
+	const label = "helm-broker.io/binding"
+	newJob := func(binding string, image string, conditions ...batchv1.JobConditionType) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis-bind-0123", Namespace: "default", Labels: map[string]string{label: binding}},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "bind", Image: image}},
+			}}},
+		}
+		for _, condition := range conditions {
+			job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: condition, Status: corev1.ConditionTrue})
+		}
+		return job
+	}
+	terminating := newJob("binding", "old")
+	terminating.DeletionTimestamp = &metav1.Time{}
+
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		canceled bool
+		image    string
+		err      bool
+	}{
+		{name: "new job", image: "new"},
+		{name: "running job", existing: []runtime.Object{newJob("binding", "old")}, image: "old"},
+		{name: "completed job", existing: []runtime.Object{newJob("binding", "old", batchv1.JobComplete)}, image: "old"},
+		{name: "failed job", existing: []runtime.Object{newJob("binding", "old", batchv1.JobFailed)}, image: "new"},
+		{name: "terminating job", existing: []runtime.Object{terminating}, canceled: true, image: "old", err: true},
+		{name: "job of another binding", existing: []runtime.Object{newJob("other", "old")}, image: "old", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(test.existing...)
+			jobs := client.BatchV1().Jobs("default")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.canceled {
+				cancel()
+			}
+
+			err := createJob(ctx, jobs, newJob("binding", "new"), label)
+			if test.err && err == nil {
+				t.Error("expected error")
+			} else if !test.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			job, err := jobs.Get("redis-bind-0123", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if image := job.Spec.Template.Spec.Containers[0].Image; image != test.image {
+				t.Errorf("expected job with image %s, got %s", test.image, image)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Package kube inspects the Kubernetes resources rendered by a release.
+package kube // import "github.com/huangjiuyuan/helm-broker/pkg/kube"
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// separator splits the documents of a rendered manifest.
+var separator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// Resource is a Kubernetes resource rendered in a release manifest.
+type Resource struct {
+	// APIVersion of the resource.
+	APIVersion string `json:"apiVersion"`
+	// Kind of the resource.
+	Kind string `json:"kind"`
+	// Metadata of the resource.
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace,omitempty"`
+	} `json:"metadata"`
+}
+
+// Name returns the name of the resource.
+func (r Resource) Name() string {
+	return r.Metadata.Name
+}
+
+// ParseManifest returns the resources rendered in a release manifest.
+func ParseManifest(manifest string) ([]Resource, error) {
+	var resources []Resource
+	for _, doc := range separator.Split(manifest, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+
+		var resource Resource
+		if err := yaml.Unmarshal([]byte(doc), &resource); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %v", err)
+		}
+		if resource.Kind == "" {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,173 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+// Readiness describes whether the resources of a release are ready.
+type Readiness struct {
+	// Ready is true if all the resources are ready.
+	Ready bool
+	// Description is a human readable summary of the resources that are not
+	// ready, such as "2/3 pods ready, PVC data-0 pending".
+	Description string
+}
+
+// GetReadiness inspects the live Deployments, StatefulSets,
+// PersistentVolumeClaims and Services of a release and reports their
+// readiness. Resources without a namespace are looked up in the release
+// namespace. Resources which do not exist yet, or any more, are reported as
+// pending, and workloads are ready once their rollout is complete.
+func GetReadiness(client kubeclientset.Interface, namespace string, resources []Resource) (*Readiness, error) {
+	var ready, desired int32
+	var pending []string
+	for _, resource := range resources {
+		ns := resource.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+
+		switch resource.Kind {
+		case "Deployment":
+			deployment, err := client.AppsV1().Deployments(ns).Get(resource.Name(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, fmt.Sprintf("deployment %s pending", resource.Name()))
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get deployment %s: %v", resource.Name(), err)
+			}
+			desired += replicas(deployment.Spec.Replicas)
+			ready += deployment.Status.ReadyReplicas
+			if !deploymentRolledOut(deployment) {
+				pending = append(pending, fmt.Sprintf("deployment %s rolling out", deployment.Name))
+			}
+		case "StatefulSet":
+			statefulSet, err := client.AppsV1().StatefulSets(ns).Get(resource.Name(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, fmt.Sprintf("statefulset %s pending", resource.Name()))
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get statefulset %s: %v", resource.Name(), err)
+			}
+			desired += replicas(statefulSet.Spec.Replicas)
+			ready += statefulSet.Status.ReadyReplicas
+			if !statefulSetRolledOut(statefulSet) {
+				pending = append(pending, fmt.Sprintf("statefulset %s rolling out", statefulSet.Name))
+			}
+
+			// Claims of a StatefulSet are created from its templates and
+			// are not part of the manifest.
+			claims, err := pendingClaims(client, statefulSet)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, claims...)
+		case "PersistentVolumeClaim":
+			pvc, err := client.CoreV1().PersistentVolumeClaims(ns).Get(resource.Name(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, fmt.Sprintf("PVC %s pending", resource.Name()))
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get persistent volume claim %s: %v", resource.Name(), err)
+			}
+			if pvc.Status.Phase != corev1.ClaimBound {
+				pending = append(pending, fmt.Sprintf("PVC %s %s", pvc.Name, strings.ToLower(string(pvc.Status.Phase))))
+			}
+		case "Service":
+			service, err := client.CoreV1().Services(ns).Get(resource.Name(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, fmt.Sprintf("service %s pending", resource.Name()))
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get service %s: %v", resource.Name(), err)
+			}
+			if service.Spec.Type == corev1.ServiceTypeLoadBalancer && len(service.Status.LoadBalancer.Ingress) == 0 {
+				pending = append(pending, fmt.Sprintf("service %s pending", service.Name))
+			}
+		}
+	}
+
+	var parts []string
+	if desired > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d pods ready", ready, desired))
+	}
+	parts = append(parts, pending...)
+
+	return &Readiness{
+		Ready:       ready >= desired && len(pending) == 0,
+		Description: strings.Join(parts, ", "),
+	}, nil
+}
+
+// deploymentRolledOut returns true if the latest spec of a Deployment was
+// observed and all its replicas were updated and are available, so that no
+// pods of a previous revision are left.
+func deploymentRolledOut(deployment *appsv1.Deployment) bool {
+	desired := replicas(deployment.Spec.Replicas)
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas >= desired &&
+		status.Replicas <= status.UpdatedReplicas &&
+		status.AvailableReplicas >= status.UpdatedReplicas
+}
+
+// statefulSetRolledOut returns true if the latest spec of a StatefulSet was
+// observed and all its replicas run the update revision. StatefulSets which
+// are updated on delete are rolled out once their spec was observed.
+func statefulSetRolledOut(statefulSet *appsv1.StatefulSet) bool {
+	status := statefulSet.Status
+	if status.ObservedGeneration < statefulSet.Generation {
+		return false
+	}
+	if statefulSet.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return true
+	}
+	if rollingUpdate := statefulSet.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		// Only the replicas above the partition are updated.
+		return status.UpdatedReplicas >= replicas(statefulSet.Spec.Replicas)-*rollingUpdate.Partition
+	}
+	return status.UpdatedReplicas >= replicas(statefulSet.Spec.Replicas) && status.CurrentRevision == status.UpdateRevision
+}
+
+// pendingClaims returns the unbound persistent volume claims created from the
+// volume claim templates of a StatefulSet.
+func pendingClaims(client kubeclientset.Interface, statefulSet *appsv1.StatefulSet) ([]string, error) {
+	if len(statefulSet.Spec.VolumeClaimTemplates) == 0 {
+		return nil, nil
+	}
+
+	claims, err := client.CoreV1().PersistentVolumeClaims(statefulSet.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistent volume claims: %v", err)
+	}
+
+	var pending []string
+	for _, template := range statefulSet.Spec.VolumeClaimTemplates {
+		prefix := fmt.Sprintf("%s-%s-", template.Name, statefulSet.Name)
+		for _, claim := range claims.Items {
+			if strings.HasPrefix(claim.Name, prefix) && claim.Status.Phase != corev1.ClaimBound {
+				pending = append(pending, fmt.Sprintf("PVC %s %s", claim.Name, strings.ToLower(string(claim.Status.Phase))))
+			}
+		}
+	}
+	return pending, nil
+}
+
+// replicas returns the desired number of replicas, which defaults to one.
+func replicas(r *int32) int32 {
+	if r == nil {
+		return 1
+	}
+	return *r
+}
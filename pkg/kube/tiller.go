@@ -0,0 +1,82 @@
+package kube
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// tillerSelector selects the pods of Tiller installed by helm init.
+	tillerSelector = "app=helm,name=tiller"
+	// tillerService is the name of the Service of Tiller installed by helm
+	// init.
+	tillerService = "tiller-deploy"
+	// tillerPortName is the name of the gRPC port of Tiller.
+	tillerPortName = "tiller"
+	// tillerPort is the default gRPC port of Tiller.
+	tillerPort = 44134
+)
+
+// FindTiller returns the host and port of the Tiller deployed in a namespace.
+// The cluster IP of the Service of Tiller is returned if it exists, since it
+// does not change when the Tiller pod moves, and the IP of a ready Tiller pod
+// otherwise.
+func FindTiller(client kubeclientset.Interface, namespace string) (string, error) {
+	podList, err := client.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: tillerSelector})
+	if err != nil {
+		return "", fmt.Errorf("failed to list tiller pods: %v", err)
+	}
+
+	var pod *corev1.Pod
+	for i := range podList.Items {
+		if isPodReady(&podList.Items[i]) {
+			pod = &podList.Items[i]
+			break
+		}
+	}
+	if pod == nil {
+		return "", fmt.Errorf("no ready tiller pod found in namespace %s", namespace)
+	}
+
+	service, err := client.CoreV1().Services(namespace).Get(tillerService, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get tiller service: %v", err)
+	} else if err == nil && service.Spec.ClusterIP != "" && service.Spec.ClusterIP != corev1.ClusterIPNone {
+		port := int32(tillerPort)
+		for _, p := range service.Spec.Ports {
+			if p.Name == tillerPortName {
+				port = p.Port
+			}
+		}
+		return net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port))), nil
+	}
+
+	port := int32(tillerPort)
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			if p.Name == tillerPortName {
+				port = p.ContainerPort
+			}
+		}
+	}
+	return net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port))), nil
+}
+
+// isPodReady returns true if a pod is running and ready.
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// UpdatePreview is the response to a request to preview an update of an
+// instance.
+type UpdatePreview struct {
+	// Revision is the current revision of the release of the instance.
+	Revision int32 `json:"revision"`
+	// ChartVersion is the version of the chart the release would be upgraded
+	// to.
+	ChartVersion string `json:"chart_version"`
+	// Diff is the line diff of the resources of the release which would
+	// change.
+	Diff string `json:"diff"`
+}
+
+// PreviewUpdateHandler is the mux handler that dispatches requests to preview
+// an update of an instance to the broker's Interface. The request body is the
+// body of an update request.
+func (s *APISurface) PreviewUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("preview_update").Inc()
+
+	request, err := unpackUpdateRequest(r, mux.Vars(r))
+	if err != nil {
+		s.writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	glog.V(4).Infof("Received PreviewUpdateRequest for instanceID %q", request.InstanceID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.PreviewUpdate(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,160 @@
+// Package rest extends the OSB REST API surface of osb-broker-lib with the
+// parts of the API the library does not cover.
+package rest // import "github.com/huangjiuyuan/helm-broker/pkg/rest"
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+	"github.com/pmorie/osb-broker-lib/pkg/metrics"
+	osbrest "github.com/pmorie/osb-broker-lib/pkg/rest"
+)
+
+// Interface contains the business logic for the broker's operations, including
+// the operations which are not part of broker.Interface.
+type Interface interface {
+	broker.Interface
+	// GetDashboardURL returns the dashboard URL of an instance, or an empty
+	// string if the instance has no dashboard yet.
+	GetDashboardURL(instanceID string, c *broker.RequestContext) (string, error)
+	// BindingLastOperation returns the state of the last asynchronous
+	// operation on a binding.
+	BindingLastOperation(request *osb.BindingLastOperationRequest, c *broker.RequestContext) (*broker.LastOperationResponse, error)
+	// GetBinding returns the credentials of a binding.
+	GetBinding(request *osb.GetBindingRequest, c *broker.RequestContext) (*osb.GetBindingResponse, error)
+	// GetInstance returns the service, plan and parameters of an instance.
+	GetInstance(request *GetInstanceRequest, c *broker.RequestContext) (*GetInstanceResponse, error)
+	// GetExtendedCatalog returns the broker's catalog of services, including
+	// the fields which are not modeled by osb.Service and osb.Plan.
+	GetExtendedCatalog(c *broker.RequestContext) (*CatalogResponse, error)
+	// UpdateInstance updates an instance, optionally to the maintenance
+	// version of its plan.
+	UpdateInstance(request *UpdateInstanceRequest, c *broker.RequestContext) (*broker.UpdateInstanceResponse, error)
+	// PreviewUpdate returns the changes an update request would make to an
+	// instance without updating it.
+	PreviewUpdate(request *UpdateInstanceRequest, c *broker.RequestContext) (*UpdatePreview, error)
+	// StartFleetUpgrade starts upgrading all instances of a service.
+	StartFleetUpgrade(request *FleetUpgradeRequest, c *broker.RequestContext) (*FleetUpgradeStatus, error)
+	// GetFleetUpgrade returns the progress of the fleet upgrade of a service.
+	GetFleetUpgrade(serviceID string, c *broker.RequestContext) (*FleetUpgradeStatus, error)
+	// PauseFleetUpgrade pauses the fleet upgrade of a service after its
+	// current wave.
+	PauseFleetUpgrade(serviceID string, c *broker.RequestContext) (*FleetUpgradeStatus, error)
+	// ResumeFleetUpgrade resumes a paused fleet upgrade of a service, retrying
+	// the failed instances.
+	ResumeFleetUpgrade(serviceID string, c *broker.RequestContext) (*FleetUpgradeStatus, error)
+}
+
+// APISurface is an osb-broker-lib APISurface with handlers for the operations
+// of Interface.
+type APISurface struct {
+	*osbrest.APISurface
+	// Broker contains the business logic that provides the implementation
+	// for the different OSB API operations.
+	Broker Interface
+}
+
+// NewAPISurface returns a new, ready-to-go APISurface.
+func NewAPISurface(brokerInterface Interface, m *metrics.OSBMetricsCollector) (*APISurface, error) {
+	api, err := osbrest.NewAPISurface(brokerInterface, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APISurface{
+		APISurface: api,
+		Broker:     brokerInterface,
+	}, nil
+}
+
+// UpdateInstanceRequest is an osb.UpdateInstanceRequest with the maintenance
+// info of the plan to update the instance to.
+type UpdateInstanceRequest struct {
+	osb.UpdateInstanceRequest
+
+	// MaintenanceInfo holds the maintenance version to update the instance
+	// to. Optional.
+	MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
+}
+
+// updateInstanceResponse is an UpdateInstanceResponse with the dashboard URL
+// of the instance.
+type updateInstanceResponse struct {
+	broker.UpdateInstanceResponse
+
+	DashboardURL *string `json:"dashboard_url,omitempty"`
+}
+
+// UpdateHandler is the mux handler that dispatches Update requests to the
+// broker's Interface and returns the dashboard URL of the updated instance.
+func (s *APISurface) UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("update").Inc()
+
+	version := getBrokerAPIVersionFromRequest(r)
+	if err := s.Broker.ValidateBrokerAPIVersion(version); err != nil {
+		s.writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	request, err := unpackUpdateRequest(r, mux.Vars(r))
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	glog.V(4).Infof("Received Update Request for instanceID %q", request.InstanceID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.UpdateInstance(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if response.Async {
+		status = http.StatusAccepted
+	}
+
+	body := updateInstanceResponse{UpdateInstanceResponse: *response}
+	dashboardURL, err := s.Broker.GetDashboardURL(request.InstanceID, c)
+	if err != nil {
+		glog.Warningf("Unable to get dashboard URL for instanceID %q - %v", request.InstanceID, err)
+	} else if dashboardURL != "" {
+		body.DashboardURL = &dashboardURL
+	}
+
+	s.writeResponse(w, status, body)
+}
+
+// unpackUpdateRequest unpacks an osb request from the given HTTP request.
+func unpackUpdateRequest(r *http.Request, vars map[string]string) (*UpdateInstanceRequest, error) {
+	osbRequest := &UpdateInstanceRequest{}
+	if err := unmarshalRequestBody(r, osbRequest); err != nil {
+		return nil, err
+	}
+
+	osbRequest.InstanceID = vars[osb.VarKeyInstanceID]
+
+	asyncQueryParamVal := r.FormValue(osb.AcceptsIncomplete)
+	if strings.ToLower(asyncQueryParamVal) == "true" {
+		osbRequest.AcceptsIncomplete = true
+	}
+	identity, err := retrieveOriginatingIdentity(r)
+	// This could be not found because platforms may support the feature
+	// but are not guaranteed to.
+	if err != nil {
+		glog.Infof("Unable to retrieve originating identity - %v", err)
+	}
+	osbRequest.OriginatingIdentity = identity
+
+	return osbRequest, nil
+}
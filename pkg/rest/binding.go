@@ -0,0 +1,222 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// BindHandler is the mux handler that dispatches bind requests to the broker's
+// Interface. Unlike the handler of osb-broker-lib, it supports asynchronous
+// bindings.
+func (s *APISurface) BindHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("bind").Inc()
+
+	version := getBrokerAPIVersionFromRequest(r)
+	if err := s.Broker.ValidateBrokerAPIVersion(version); err != nil {
+		s.writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	request, err := unpackBindRequest(r)
+	if err != nil {
+		s.writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	glog.V(4).Infof("Received BindRequest for instanceID %q, bindingID %q", request.InstanceID, request.BindingID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.Bind(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if response.Async {
+		status = http.StatusAccepted
+	} else if response.Exists {
+		status = http.StatusOK
+	}
+
+	s.writeResponse(w, status, response)
+}
+
+// unpackBindRequest unpacks an osb request from the given HTTP request.
+func unpackBindRequest(r *http.Request) (*osb.BindRequest, error) {
+	osbRequest := &osb.BindRequest{}
+	if err := unmarshalRequestBody(r, osbRequest); err != nil {
+		return nil, err
+	}
+
+	vars := mux.Vars(r)
+	osbRequest.InstanceID = vars[osb.VarKeyInstanceID]
+	osbRequest.BindingID = vars[osb.VarKeyBindingID]
+
+	asyncQueryParamVal := r.URL.Query().Get(osb.AcceptsIncomplete)
+	if strings.ToLower(asyncQueryParamVal) == "true" {
+		osbRequest.AcceptsIncomplete = true
+	}
+	identity, err := retrieveOriginatingIdentity(r)
+	// This could be not found because platforms may support the feature
+	// but are not guaranteed to.
+	if err != nil {
+		glog.Infof("Unable to retrieve originating identity - %v", err)
+	}
+	osbRequest.OriginatingIdentity = identity
+
+	return osbRequest, nil
+}
+
+// UnbindHandler is the mux handler that dispatches unbind requests to the
+// broker's Interface. Unlike the handler of osb-broker-lib, it supports
+// asynchronous unbinding.
+func (s *APISurface) UnbindHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("unbind").Inc()
+
+	version := getBrokerAPIVersionFromRequest(r)
+	if err := s.Broker.ValidateBrokerAPIVersion(version); err != nil {
+		s.writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	request := unpackUnbindRequest(r)
+
+	glog.V(4).Infof("Received UnbindRequest for instanceID %q, bindingID %q", request.InstanceID, request.BindingID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.Unbind(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if response.Async {
+		status = http.StatusAccepted
+	}
+
+	s.writeResponse(w, status, response)
+}
+
+// unpackUnbindRequest unpacks an osb request from the given HTTP request.
+func unpackUnbindRequest(r *http.Request) *osb.UnbindRequest {
+	osbRequest := &osb.UnbindRequest{}
+
+	vars := mux.Vars(r)
+	osbRequest.InstanceID = vars[osb.VarKeyInstanceID]
+	osbRequest.BindingID = vars[osb.VarKeyBindingID]
+	osbRequest.ServiceID = r.FormValue(osb.VarKeyServiceID)
+	osbRequest.PlanID = r.FormValue(osb.VarKeyPlanID)
+
+	asyncQueryParamVal := r.FormValue(osb.AcceptsIncomplete)
+	if strings.ToLower(asyncQueryParamVal) == "true" {
+		osbRequest.AcceptsIncomplete = true
+	}
+	identity, err := retrieveOriginatingIdentity(r)
+	// This could be not found because platforms may support the feature
+	// but are not guaranteed to.
+	if err != nil {
+		glog.Infof("Unable to retrieve originating identity - %v", err)
+	}
+	osbRequest.OriginatingIdentity = identity
+
+	return osbRequest
+}
+
+// BindingLastOperationHandler is the mux handler that dispatches binding
+// last-operation requests to the broker's Interface.
+func (s *APISurface) BindingLastOperationHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("binding_last_operation").Inc()
+
+	version := getBrokerAPIVersionFromRequest(r)
+	if err := s.Broker.ValidateBrokerAPIVersion(version); err != nil {
+		s.writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	request := unpackBindingLastOperationRequest(r)
+
+	glog.V(4).Infof("Received BindingLastOperationRequest for instanceID %q, bindingID %q", request.InstanceID, request.BindingID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.BindingLastOperation(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeResponse(w, http.StatusOK, response)
+}
+
+// unpackBindingLastOperationRequest unpacks an osb request from the given HTTP
+// request.
+func unpackBindingLastOperationRequest(r *http.Request) *osb.BindingLastOperationRequest {
+	osbRequest := &osb.BindingLastOperationRequest{}
+
+	vars := mux.Vars(r)
+	osbRequest.InstanceID = vars[osb.VarKeyInstanceID]
+	osbRequest.BindingID = vars[osb.VarKeyBindingID]
+	if serviceID := r.FormValue(osb.VarKeyServiceID); serviceID != "" {
+		osbRequest.ServiceID = &serviceID
+	}
+	if planID := r.FormValue(osb.VarKeyPlanID); planID != "" {
+		osbRequest.PlanID = &planID
+	}
+	if operation := r.FormValue(osb.VarKeyOperation); operation != "" {
+		typedOperation := osb.OperationKey(operation)
+		osbRequest.OperationKey = &typedOperation
+	}
+
+	return osbRequest
+}
+
+// GetBindingHandler is the mux handler that dispatches requests to fetch a
+// binding to the broker's Interface.
+func (s *APISurface) GetBindingHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("get_binding").Inc()
+
+	version := getBrokerAPIVersionFromRequest(r)
+	if err := s.Broker.ValidateBrokerAPIVersion(version); err != nil {
+		s.writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	request := &osb.GetBindingRequest{
+		InstanceID: vars[osb.VarKeyInstanceID],
+		BindingID:  vars[osb.VarKeyBindingID],
+	}
+
+	glog.V(4).Infof("Received GetBindingRequest for instanceID %q, bindingID %q", request.InstanceID, request.BindingID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.GetBinding(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"net/http"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// CatalogResponse is an osb.CatalogResponse with the fields of newer OSB API
+// versions which are not modeled by osb.Service and osb.Plan.
+type CatalogResponse struct {
+	Services []Service `json:"services"`
+}
+
+// Service is an osb.Service with the fields of newer OSB API versions.
+type Service struct {
+	osb.Service
+
+	// InstancesRetrievable represents whether fetching a service instance
+	// via a GET on the instance's endpoint is supported.
+	InstancesRetrievable bool `json:"instances_retrievable,omitempty"`
+	// Plans is the list of the Plans for a service.
+	Plans []Plan `json:"plans"`
+}
+
+// Plan is an osb.Plan with the fields of newer OSB API versions.
+type Plan struct {
+	osb.Plan
+
+	// MaintenanceInfo holds the maintenance version of the plan.
+	MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
+}
+
+// MaintenanceInfo holds the version of the software which instances of a plan
+// are provisioned or updated with.
+type MaintenanceInfo struct {
+	// Version is the semantic version of the maintenance of a plan.
+	Version string `json:"version"`
+	// Description of the maintenance.
+	Description string `json:"description,omitempty"`
+}
+
+// ToCatalogResponse returns the osb.CatalogResponse of the catalog, without
+// the fields which are not modeled by osb.Service and osb.Plan.
+func (r *CatalogResponse) ToCatalogResponse() *osb.CatalogResponse {
+	response := &osb.CatalogResponse{
+		Services: make([]osb.Service, len(r.Services)),
+	}
+	for i, service := range r.Services {
+		response.Services[i] = service.Service
+		response.Services[i].Plans = make([]osb.Plan, len(service.Plans))
+		for j, plan := range service.Plans {
+			response.Services[i].Plans[j] = plan.Plan
+		}
+	}
+	return response
+}
+
+// GetCatalogHandler is the mux handler that dispatches requests to get the
+// broker's catalog to the broker's Interface.
+func (s *APISurface) GetCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("get_catalog").Inc()
+
+	version := getBrokerAPIVersionFromRequest(r)
+	if err := s.Broker.ValidateBrokerAPIVersion(version); err != nil {
+		s.writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.GetExtendedCatalog(c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,125 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// varKeyServiceID is the mux variable holding the service ID of a fleet
+// upgrade.
+const varKeyServiceID = "service_id"
+
+// FleetUpgradeRequest is a request to upgrade all instances of a service.
+type FleetUpgradeRequest struct {
+	// ServiceID is the ID of the service whose instances are upgraded.
+	ServiceID string `json:"service_id"`
+	// ChartVersion is the version of the chart the instances are upgraded
+	// to. The version of the plan of each instance is used if it is empty.
+	ChartVersion string `json:"chart_version,omitempty"`
+	// CanarySize is the number of instances upgraded in each wave until an
+	// instance was upgraded successfully. Optional.
+	CanarySize int `json:"canary_size,omitempty"`
+	// BatchSize is the number of instances upgraded in each following wave.
+	// Optional.
+	BatchSize int `json:"batch_size,omitempty"`
+}
+
+// FleetUpgradeStatus is the progress of a fleet upgrade.
+type FleetUpgradeStatus struct {
+	// ServiceID is the ID of the service whose instances are upgraded.
+	ServiceID string `json:"service_id"`
+	// ChartVersion is the version of the chart the instances are upgraded
+	// to.
+	ChartVersion string `json:"chart_version,omitempty"`
+	// State is one of "running", "paused" or "completed".
+	State string `json:"state"`
+	// Waves is the number of waves started.
+	Waves int `json:"waves"`
+	// Instances holds the progress of each instance.
+	Instances []FleetUpgradeInstance `json:"instances"`
+}
+
+// FleetUpgradeInstance is the progress of the upgrade of an instance by a fleet
+// upgrade.
+type FleetUpgradeInstance struct {
+	// InstanceID is the ID of the instance.
+	InstanceID string `json:"instance_id"`
+	// Name of the instance.
+	Name string `json:"name"`
+	// Namespace of the instance.
+	Namespace string `json:"namespace"`
+	// State is one of "pending", "upgrading", "upgraded" or "failed".
+	State string `json:"state"`
+	// Description describes the failure of the upgrade.
+	Description string `json:"description,omitempty"`
+}
+
+// StartFleetUpgradeHandler is the mux handler that dispatches requests to start
+// a fleet upgrade to the broker's Interface.
+func (s *APISurface) StartFleetUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("start_fleet_upgrade").Inc()
+
+	request := &FleetUpgradeRequest{}
+	if err := unmarshalRequestBody(r, request); err != nil {
+		s.writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	glog.V(4).Infof("Received StartFleetUpgradeRequest for serviceID %q", request.ServiceID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.StartFleetUpgrade(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeResponse(w, http.StatusAccepted, response)
+}
+
+// GetFleetUpgradeHandler is the mux handler that dispatches requests to get the
+// progress of a fleet upgrade to the broker's Interface.
+func (s *APISurface) GetFleetUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	s.fleetUpgradeHandler(w, r, "get_fleet_upgrade", s.Broker.GetFleetUpgrade)
+}
+
+// PauseFleetUpgradeHandler is the mux handler that dispatches requests to pause
+// a fleet upgrade to the broker's Interface.
+func (s *APISurface) PauseFleetUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	s.fleetUpgradeHandler(w, r, "pause_fleet_upgrade", s.Broker.PauseFleetUpgrade)
+}
+
+// ResumeFleetUpgradeHandler is the mux handler that dispatches requests to
+// resume a fleet upgrade to the broker's Interface.
+func (s *APISurface) ResumeFleetUpgradeHandler(w http.ResponseWriter, r *http.Request) {
+	s.fleetUpgradeHandler(w, r, "resume_fleet_upgrade", s.Broker.ResumeFleetUpgrade)
+}
+
+// fleetUpgradeHandler dispatches a request on the fleet upgrade of the service
+// in the request path.
+func (s *APISurface) fleetUpgradeHandler(w http.ResponseWriter, r *http.Request, action string, f func(string, *broker.RequestContext) (*FleetUpgradeStatus, error)) {
+	s.Metrics.Actions.WithLabelValues(action).Inc()
+
+	serviceID := mux.Vars(r)[varKeyServiceID]
+	glog.V(4).Infof("Received %s request for serviceID %q", action, serviceID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := f(serviceID, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeResponse(w, http.StatusOK, response)
+}
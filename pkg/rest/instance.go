@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	"github.com/pmorie/osb-broker-lib/pkg/broker"
+)
+
+// GetInstanceRequest represents a request to do a GET on a particular
+// instance.
+type GetInstanceRequest struct {
+	// InstanceID is the ID of the instance to fetch.
+	InstanceID string `json:"instance_id"`
+}
+
+// GetInstanceResponse is sent as the response to doing a GET on a particular
+// instance.
+type GetInstanceResponse struct {
+	// ServiceID is the ID of the service the instance is provisioned from.
+	ServiceID string `json:"service_id"`
+	// PlanID is the ID of the plan the instance is provisioned from.
+	PlanID string `json:"plan_id"`
+	// DashboardURL is the URL of a web-based management user interface for
+	// the instance.
+	DashboardURL *string `json:"dashboard_url,omitempty"`
+	// Parameters is the set of configuration options of the instance.
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// GetInstanceHandler is the mux handler that dispatches requests to fetch an
+// instance to the broker's Interface.
+func (s *APISurface) GetInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	s.Metrics.Actions.WithLabelValues("get_instance").Inc()
+
+	version := getBrokerAPIVersionFromRequest(r)
+	if err := s.Broker.ValidateBrokerAPIVersion(version); err != nil {
+		s.writeError(w, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	request := &GetInstanceRequest{
+		InstanceID: mux.Vars(r)[osb.VarKeyInstanceID],
+	}
+
+	glog.V(4).Infof("Received GetInstanceRequest for instanceID %q", request.InstanceID)
+
+	c := &broker.RequestContext{
+		Writer:  w,
+		Request: r,
+	}
+
+	response, err := s.Broker.GetInstance(request, c)
+	if err != nil {
+		s.writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,49 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pmorie/osb-broker-lib/pkg/server"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewServer creates a new osb-broker-lib Server and registers the endpoints of
+// the APISurface, which take precedence over the endpoints of osb-broker-lib.
+func NewServer(api *APISurface, reg prom.Gatherer) *server.Server {
+	router := mux.NewRouter()
+
+	if api.EnableCORS {
+		router.Methods("OPTIONS").HandlerFunc(api.OptionsHandler)
+	}
+
+	registerAPIHandlers(router, api)
+	router.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	return &server.Server{
+		Router: router,
+	}
+}
+
+// registerAPIHandlers registers the APISurface endpoints and handlers.
+func registerAPIHandlers(router *mux.Router, api *APISurface) {
+	router.HandleFunc("/v2/catalog", api.GetCatalogHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/last_operation", api.LastOperationHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}", api.GetInstanceHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}", api.ProvisionHandler).Methods("PUT")
+	router.HandleFunc("/v2/service_instances/{instance_id}", api.DeprovisionHandler).Methods("DELETE")
+	router.HandleFunc("/v2/service_instances/{instance_id}", api.UpdateHandler).Methods("PATCH")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}/last_operation", api.BindingLastOperationHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", api.GetBindingHandler).Methods("GET")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", api.BindHandler).Methods("PUT")
+	router.HandleFunc("/v2/service_instances/{instance_id}/service_bindings/{binding_id}", api.UnbindHandler).Methods("DELETE")
+	router.HandleFunc("/admin/service_instances/{instance_id}/update_preview", api.PreviewUpdateHandler).Methods("POST")
+	router.HandleFunc("/admin/fleet_upgrades", api.StartFleetUpgradeHandler).Methods("POST")
+	router.HandleFunc("/admin/fleet_upgrades/{service_id}", api.GetFleetUpgradeHandler).Methods("GET")
+	router.HandleFunc("/admin/fleet_upgrades/{service_id}/pause", api.PauseFleetUpgradeHandler).Methods("POST")
+	router.HandleFunc("/admin/fleet_upgrades/{service_id}/resume", api.ResumeFleetUpgradeHandler).Methods("POST")
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+}
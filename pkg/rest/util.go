@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+)
+
+func getBrokerAPIVersionFromRequest(r *http.Request) string {
+	return r.Header.Get(osb.APIVersionHeader)
+}
+
+func unmarshalRequestBody(request *http.Request, obj interface{}) error {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, obj)
+}
+
+// retrieveOriginatingIdentity retrieves the originating identity from
+// the request header.
+func retrieveOriginatingIdentity(r *http.Request) (*osb.OriginatingIdentity, error) {
+	identityHeader := r.Header.Get(osb.OriginatingIdentityHeader)
+	if identityHeader == "" {
+		return nil, fmt.Errorf("unable to find originating identity")
+	}
+
+	identitySlice := strings.Split(identityHeader, " ")
+	if len(identitySlice) != 2 {
+		glog.Infof("invalid header for originating origin - %v", identityHeader)
+		return nil, fmt.Errorf("invalid originating identity header")
+	}
+	// Base64 decode the value string so the value is passed as valid JSON.
+	val, err := base64.StdEncoding.DecodeString(identitySlice[1])
+	if err != nil {
+		glog.Infof("invalid header for originating origin - %v", identityHeader)
+		return nil, fmt.Errorf("invalid encoding for value of originating identity header")
+	}
+	return &osb.OriginatingIdentity{
+		Platform: identitySlice[0],
+		Value:    string(val),
+	}, nil
+}
+
+// writeResponse will serialize 'object' to the HTTP ResponseWriter
+// using the 'code' as the HTTP status code.
+func (s *APISurface) writeResponse(w http.ResponseWriter, code int, object interface{}) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.EnableCORS {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, X-Broker-API-Version, X-Broker-API-Originating-Identity, Content-Type, Authorization, Accept")
+	}
+
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// writeError writes an osb.HTTPStatusCodeError with its own status code, or
+// any other error with the given default status code.
+func (s *APISurface) writeError(w http.ResponseWriter, err error, defaultStatusCode int) {
+	if httpErr, ok := osb.IsHTTPError(err); ok {
+		type e struct {
+			ErrorMessage *string `json:"error,omitempty"`
+			Description  *string `json:"description,omitempty"`
+		}
+		s.writeResponse(w, httpErr.StatusCode, &e{
+			ErrorMessage: httpErr.ErrorMessage,
+			Description:  httpErr.Description,
+		})
+		return
+	}
+
+	type e struct {
+		Description string `json:"description"`
+	}
+	s.writeResponse(w, defaultStatusCode, &e{
+		Description: err.Error(),
+	})
+}
@@ -0,0 +1,240 @@
+// Package store persists the state of the broker in Kubernetes Secrets.
+package store // import "github.com/huangjiuyuan/helm-broker/pkg/store"
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// kindLabel is the label holding the kind of the record stored in a Secret.
+	kindLabel = "helm-broker.io/kind"
+	// instanceLabel is the label holding the hashed instance ID of a record.
+	instanceLabel = "helm-broker.io/instance"
+	// recordKey is the key of the Secret data holding the record.
+	recordKey = "record"
+	// putAttempts is the number of attempts to store a record which is
+	// modified concurrently.
+	putAttempts = 5
+)
+
+// ErrNotFound is returned if a record does not exist.
+var ErrNotFound = errors.New("record not found")
+
+// Binding is the record of a service binding.
+type Binding struct {
+	// InstanceID is the ID of the instance the binding is for.
+	InstanceID string `json:"instanceID"`
+	// BindingID is the ID of the binding.
+	BindingID string `json:"bindingID"`
+	// Parameters holds the parameters of the bind request.
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Credentials holds the credentials of the binding.
+	Credentials map[string]interface{} `json:"credentials,omitempty"`
+	// Operation is the last operation on the binding.
+	Operation osb.OperationKey `json:"operation"`
+	// State is the state of the last operation on the binding.
+	State osb.LastOperationState `json:"state"`
+	// Description describes the state of the last operation on the binding.
+	Description string `json:"description,omitempty"`
+}
+
+// Instance is the record of the last operation on a service instance whose
+// state is not reflected by its release.
+type Instance struct {
+	// InstanceID is the ID of the instance.
+	InstanceID string `json:"instanceID"`
+	// Revision is the revision of the release the record applies to.
+	Revision int32 `json:"revision"`
+	// Operation is the last operation on the instance.
+	Operation osb.OperationKey `json:"operation"`
+	// State is the state of the last operation on the instance.
+	State osb.LastOperationState `json:"state"`
+	// Description describes the state of the last operation on the instance.
+	Description string `json:"description,omitempty"`
+}
+
+// Placement is the record of the cluster holding the release of a service
+// instance.
+type Placement struct {
+	// InstanceID is the ID of the instance.
+	InstanceID string `json:"instanceID"`
+	// Cluster is the name of the cluster holding the release of the
+	// instance.
+	Cluster string `json:"cluster"`
+}
+
+// Store persists records of the broker in Secrets of a namespace.
+type Store struct {
+	// Clientset for kubernetes.
+	client kubeclientset.Interface
+	// Namespace of the Secrets.
+	namespace string
+}
+
+// NewStore creates a new store which keeps its records in the namespace.
+func NewStore(client kubeclientset.Interface, namespace string) *Store {
+	return &Store{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// GetBinding returns the record of a binding, or ErrNotFound if the binding
+// does not exist.
+func (s *Store) GetBinding(instanceID string, bindingID string) (*Binding, error) {
+	binding := &Binding{}
+	if err := s.get(bindingSecretName(bindingID), binding); err != nil {
+		return nil, err
+	}
+	if binding.InstanceID != instanceID {
+		return nil, ErrNotFound
+	}
+	return binding, nil
+}
+
+// PutBinding creates or updates the record of a binding.
+func (s *Store) PutBinding(binding *Binding) error {
+	return s.put(bindingSecretName(binding.BindingID), "binding", binding.InstanceID, binding)
+}
+
+// DeleteBinding deletes the record of a binding. Bindings which do not exist
+// are ignored.
+func (s *Store) DeleteBinding(instanceID string, bindingID string) error {
+	return s.delete(bindingSecretName(bindingID))
+}
+
+// bindingSecretName returns the name of the Secret holding a binding. The
+// binding ID is hashed since OSB IDs are not valid Secret names in general.
+func bindingSecretName(bindingID string) string {
+	return fmt.Sprintf("helm-broker-binding-%x", sha256.Sum256([]byte(bindingID)))[:52]
+}
+
+// GetInstance returns the record of an instance, or ErrNotFound if there is no
+// record of the instance.
+func (s *Store) GetInstance(instanceID string) (*Instance, error) {
+	instance := &Instance{}
+	if err := s.get(instanceSecretName(instanceID), instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// PutInstance creates or updates the record of an instance.
+func (s *Store) PutInstance(instance *Instance) error {
+	return s.put(instanceSecretName(instance.InstanceID), "instance", instance.InstanceID, instance)
+}
+
+// DeleteInstance deletes the record of an instance. Instances without a record
+// are ignored.
+func (s *Store) DeleteInstance(instanceID string) error {
+	return s.delete(instanceSecretName(instanceID))
+}
+
+// instanceSecretName returns the name of the Secret holding an instance.
+func instanceSecretName(instanceID string) string {
+	return fmt.Sprintf("helm-broker-instance-%x", sha256.Sum256([]byte(instanceID)))[:53]
+}
+
+// GetPlacement returns the placement of an instance, or ErrNotFound if there is
+// no placement of the instance.
+func (s *Store) GetPlacement(instanceID string) (*Placement, error) {
+	placement := &Placement{}
+	if err := s.get(placementSecretName(instanceID), placement); err != nil {
+		return nil, err
+	}
+	return placement, nil
+}
+
+// PutPlacement creates or updates the placement of an instance.
+func (s *Store) PutPlacement(placement *Placement) error {
+	return s.put(placementSecretName(placement.InstanceID), "placement", placement.InstanceID, placement)
+}
+
+// DeletePlacement deletes the placement of an instance. Instances without a
+// placement are ignored.
+func (s *Store) DeletePlacement(instanceID string) error {
+	return s.delete(placementSecretName(instanceID))
+}
+
+// placementSecretName returns the name of the Secret holding the placement of
+// an instance.
+func placementSecretName(instanceID string) string {
+	return fmt.Sprintf("helm-broker-placement-%x", sha256.Sum256([]byte(instanceID)))[:54]
+}
+
+// get decodes the record stored in a Secret.
+func (s *Store) get(name string, record interface{}) error {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to get record %s: %v", name, err)
+	}
+
+	if err := json.Unmarshal(secret.Data[recordKey], record); err != nil {
+		return fmt.Errorf("failed to decode record %s: %v", name, err)
+	}
+	return nil
+}
+
+// put stores a record in a Secret, which is created if it does not exist. The
+// record is stored again if the Secret is created or updated concurrently.
+func (s *Store) put(name string, kind string, instanceID string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode record %s: %v", name, err)
+	}
+
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	for attempt := 1; ; attempt++ {
+		secret, err := secrets.Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: s.namespace,
+					Labels: map[string]string{
+						kindLabel:     kind,
+						instanceLabel: instanceLabelValue(instanceID),
+					},
+				},
+				Data: map[string][]byte{recordKey: data},
+			}
+			_, err = secrets.Create(secret)
+		} else if err == nil {
+			secret.Data = map[string][]byte{recordKey: data}
+			_, err = secrets.Update(secret)
+		}
+		if (apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err)) && attempt < putAttempts {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store record %s: %v", name, err)
+		}
+		return nil
+	}
+}
+
+// instanceLabelValue returns the value of the instance label of a record. The
+// instance ID is hashed since OSB IDs are not valid label values in general.
+func instanceLabelValue(instanceID string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(instanceID)))[:63]
+}
+
+// delete deletes the Secret of a record.
+func (s *Store) delete(name string) error {
+	err := s.client.CoreV1().Secrets(s.namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete record %s: %v", name, err)
+	}
+	return nil
+}
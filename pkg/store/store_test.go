@@ -0,0 +1,158 @@
+package store
+
+import (
+	"testing"
+
+	osb "github.com/pmorie/go-open-service-broker-client/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStoreBinding(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := NewStore(client, "broker")
+
+	if _, err := s.GetBinding("instance", "binding"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	binding := &Binding{
+		InstanceID:  "instance",
+		BindingID:   "binding",
+		Credentials: map[string]interface{}{"password": "secret"},
+		Operation:   "bind",
+		State:       osb.StateInProgress,
+	}
+	if err := s.PutBinding(binding); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	binding.State = osb.StateSucceeded
+	if err := s.PutBinding(binding); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	actual, err := s.GetBinding("instance", "binding")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual.State != osb.StateSucceeded || actual.Credentials["password"] != "secret" {
+		t.Errorf("expected the stored binding, got %+v", actual)
+	}
+	if _, err := s.GetBinding("other", "binding"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a binding of another instance, got %v", err)
+	}
+
+	secret, err := client.CoreV1().Secrets("broker").Get(bindingSecretName("binding"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret.Labels[kindLabel] != "binding" || secret.Labels[instanceLabel] != instanceLabelValue("instance") {
+		t.Errorf("expected binding labels, got %v", secret.Labels)
+	}
+
+	if err := s.DeleteBinding("instance", "binding"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.GetBinding("instance", "binding"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := s.DeleteBinding("instance", "binding"); err != nil {
+		t.Errorf("expected missing binding to be ignored, got %v", err)
+	}
+}
+
+func TestStoreInstanceAndPlacement(t *testing.T) {
+	s := NewStore(fake.NewSimpleClientset(), "broker")
+
+	if err := s.PutInstance(&Instance{InstanceID: "instance", Revision: 2, State: osb.StateFailed}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.PutPlacement(&Placement{InstanceID: "instance", Cluster: "eu"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	instance, err := s.GetInstance("instance")
+	if err != nil || instance.Revision != 2 || instance.State != osb.StateFailed {
+		t.Errorf("expected the stored instance, got %+v and %v", instance, err)
+	}
+	placement, err := s.GetPlacement("instance")
+	if err != nil || placement.Cluster != "eu" {
+		t.Errorf("expected the stored placement, got %+v and %v", placement, err)
+	}
+
+	if err := s.DeletePlacement("instance"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.GetPlacement("instance"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := s.GetInstance("instance"); err != nil {
+		t.Errorf("expected instance to be kept, got %v", err)
+	}
+}
+
+func TestStorePutConflicts(t *testing.T) {
+	resource := schema.GroupResource{Resource: "secrets"}
+	tests := []struct {
+		name      string
+		existing  bool
+		conflicts int
+		err       bool
+	}{
+		{name: "created"},
+		{name: "created concurrently", conflicts: 1},
+		{name: "updated", existing: true},
+		{name: "updated concurrently", existing: true, conflicts: putAttempts - 1},
+		{name: "too many conflicts", existing: true, conflicts: putAttempts, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			s := NewStore(client, "broker")
+			if test.existing {
+				if err := s.PutInstance(&Instance{InstanceID: "instance", Revision: 1}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			conflicts := 0
+			client.PrependReactor("*", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				name := instanceSecretName("instance")
+				switch {
+				case conflicts == test.conflicts:
+					return false, nil, nil
+				case action.GetVerb() == "create":
+					conflicts++
+					return true, nil, apierrors.NewAlreadyExists(resource, name)
+				case action.GetVerb() == "update":
+					conflicts++
+					return true, nil, apierrors.NewConflict(resource, name, nil)
+				}
+				return false, nil, nil
+			})
+
+			err := s.PutInstance(&Instance{InstanceID: "instance", Revision: 2})
+			if test.err {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if conflicts != test.conflicts {
+				t.Errorf("expected %d conflicts, got %d", test.conflicts, conflicts)
+			}
+			instance, err := s.GetInstance("instance")
+			if err != nil || instance.Revision != 2 {
+				t.Errorf("expected revision 2 to be stored, got %+v and %v", instance, err)
+			}
+		})
+	}
+}